@@ -0,0 +1,54 @@
+package livepkg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Build writes the merged bundles "~pkg.js" and "~pkg.css" together with
+// their source maps into dir. Other sources, such as assets referenced by
+// stylesheets, are copied into dir keeping their paths, so that dir can be
+// served as plain static files. dir may be inside Root, directories
+// containing "~pkg.js" are not scanned for package providers.
+// Build fails with a *ModuleError when sources use import or export statements.
+func (b *Bundle) Build(dir string) error {
+	if err := b.checkModules(); err != nil {
+		return err
+	}
+
+	for _, ext := range []string{".js", ".css"} {
+		merged := b.Merged(ext)
+		if err := writeBuildFile(dir, merged.Name, merged.Content); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(merged.Map)
+		if err != nil {
+			return err
+		}
+		if err := writeBuildFile(dir, merged.Name+".map", data); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range b.All() {
+		if src.Ext == ".js" || src.Ext == ".css" {
+			continue
+		}
+		if err := writeBuildFile(dir, src.Path, src.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBuildFile writes data to name in dir, creating directories as needed
+func writeBuildFile(dir, name string, data []byte) error {
+	target := filepath.Join(dir, filepath.FromSlash(filepath.Clean("/"+name)))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, data, 0644)
+}
@@ -2,12 +2,13 @@ package livepkg
 
 import (
 	"bytes"
-	"fmt"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -24,6 +25,10 @@ func (errs Errors) Error() string {
 	return strings.Join(msgs, "\n")
 }
 
+// Unwrap returns the wrapped errors, so that errors.Is and errors.As
+// can find them
+func (errs Errors) Unwrap() []error { return errs }
+
 // Nilify returns nil, if there are no errors
 func (errs Errors) Nilify() error {
 	if len(errs) == 0 {
@@ -38,9 +43,30 @@ type Bundle struct {
 	Root http.FileSystem
 	// Main is the root files that are used to find rest of the files
 	Main []string
-
-	// sources contains the list of reloaded files
-	sources atomic.Value
+	// Processors maps file extension to processors that turn
+	// Source.Content into Source.Processed
+	Processors map[string][]Processor
+	// Minify enables built-in minification of merged bundles
+	Minify bool
+	// Resolver finds files for dependencies, NodeResolver is used when nil
+	Resolver Resolver
+	// Aliases maps dependency prefixes to other locations, e.g.
+	// "@shared/" to "/vendor/shared/". The longest matching prefix is
+	// replaced before the dependency is resolved.
+	Aliases map[string]string
+
+	// snapshot contains the *Snapshot of the last reload
+	snapshot atomic.Value
+	// reloadmu serializes reloads
+	reloadmu sync.Mutex
+	// flight is the full reload that is in progress
+	flight reloadFlight
+	// retried is the time unresolved package names were last retried
+	retried time.Time
+	// cache contains merged bundles for the current snapshot
+	cache mergeCache
+	// providers finds files that declare packages
+	providers providerIndex
 }
 
 // NewBundle returns a empty bundle
@@ -49,7 +75,7 @@ func NewBundle(root http.FileSystem, main ...string) *Bundle {
 		Root: root,
 		Main: main,
 	}
-	bundle.sources.Store([]*Source{})
+	bundle.snapshot.Store(newSnapshot(0, []*Source{}, Errors{}))
 	return bundle
 }
 
@@ -60,15 +86,148 @@ type Change struct {
 	Deps bool    `json:"deps"` // Deps is true if dependencies changed
 }
 
-// current returns the state after the last reload
-func (b *Bundle) current() []*Source { return b.sources.Load().([]*Source) }
+// Snapshot returns the state after the last reload
+func (b *Bundle) Snapshot() *Snapshot { return b.snapshot.Load().(*Snapshot) }
+
+// current returns the sources after the last reload
+func (b *Bundle) current() []*Source { return b.Snapshot().Sources }
 
 // Reload reloads the content from Root and returns the list of changes and
-// all errors that occurred
+// all errors that occurred, changes are sorted by dependencies.
+// Reload is safe to call concurrently, calls that overlap share the same
+// reload and get the same changes, which must not be modified.
 func (b *Bundle) Reload() ([]*Change, error) {
+	_, changes, err := b.ReloadSnapshot()
+	return changes, err
+}
+
+// ReloadSnapshot is like Reload, but also returns the snapshot that
+// the changes lead to. When nothing changed it's the current snapshot.
+func (b *Bundle) ReloadSnapshot() (*Snapshot, []*Change, error) {
+	return b.flight.do(func() (*Snapshot, []*Change, error) {
+		b.reloadmu.Lock()
+		defer b.reloadmu.Unlock()
+
+		b.providers.invalidate()
+		return b.reload(nil)
+	})
+}
+
+// reloadFlight shares the result of a reload with the calls that
+// start while it is in progress
+type reloadFlight struct {
+	mu   sync.Mutex
+	call *reloadCall
+}
+
+// reloadCall is a reload in progress or completed
+type reloadCall struct {
+	done    chan struct{}
+	snap    *Snapshot
+	changes []*Change
+	err     error
+}
+
+// do calls reload, unless there's a call already in progress,
+// in which case it waits for it and returns its results
+func (flight *reloadFlight) do(reload func() (*Snapshot, []*Change, error)) (*Snapshot, []*Change, error) {
+	flight.mu.Lock()
+	if call := flight.call; call != nil {
+		flight.mu.Unlock()
+		<-call.done
+		return call.snap, call.changes, call.err
+	}
+	call := &reloadCall{done: make(chan struct{})}
+	flight.call = call
+	flight.mu.Unlock()
+
+	defer func() {
+		flight.mu.Lock()
+		flight.call = nil
+		flight.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.snap, call.changes, call.err = reload()
+	return call.snap, call.changes, call.err
+}
+
+// ReloadFiles is like Reload, but only the listed files are checked for
+// changes. Files that haven't been loaded yet and sources with glob,
+// directory or package dependencies are always checked.
+// It is meant to be used with a file system watcher.
+func (b *Bundle) ReloadFiles(paths ...string) ([]*Change, error) {
+	_, changes, err := b.reloadFiles(paths)
+	return changes, err
+}
+
+// reloadFiles implements ReloadFiles and returns the snapshot as well
+func (b *Bundle) reloadFiles(paths []string) (*Snapshot, []*Change, error) {
+	b.reloadmu.Lock()
+	defer b.reloadmu.Unlock()
+
+	modified := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		modified[path] = true
+		if filepath.Ext(path) == ".js" {
+			b.providers.invalidate()
+		}
+	}
+	return b.reload(modified)
+}
+
+// affects returns whether a change of the file at path may change the
+// bundle, empty path means any file. Other files, such as logs written
+// inside Root, don't affect the bundle.
+func (b *Bundle) affects(path string) bool {
+	snap := b.Snapshot()
+	if path == "" || snap.Source(path) != nil || len(snap.Dependents(path)) > 0 {
+		return true
+	}
+	for _, main := range b.Main {
+		if main == path {
+			return true
+		}
+	}
+	if filepath.Base(path) == "package.json" {
+		// may change how dependencies are resolved
+		return true
+	}
+	for _, src := range snap.Sources {
+		if src.unresolved && filepath.Ext(path) == ".js" {
+			// may provide the missing package
+			return true
+		}
+		for _, pattern := range src.patterns {
+			if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) || globMatches(pattern, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reload reloads sources, when modified is not nil only the sources
+// in modified are reloaded, b.reloadmu must be held
+func (b *Bundle) reload(modified map[string]bool) (*Snapshot, []*Change, error) {
 	var errs Errors
 
-	current := b.current()
+	snap := b.Snapshot()
+	current := snap.Sources
+
+	// unresolved package names are retried when a JavaScript file changes,
+	// when polling they are retried only every providerRetry, because
+	// finding new providers has to scan all files in Root
+	retryProviders := false
+	if modified == nil {
+		retryProviders = time.Since(b.retried) >= providerRetry
+	}
+	for path := range modified {
+		retryProviders = retryProviders || filepath.Ext(path) == ".js"
+	}
+	if retryProviders {
+		b.retried = time.Now()
+	}
 
 	track := make(map[string]*Change, len(current))
 	unchecked := append([]string{}, b.Main...)
@@ -91,7 +250,9 @@ func (b *Bundle) Reload() ([]*Change, error) {
 			source, err := b.Load(path)
 			if err != nil && err != ErrUnknownImport {
 				errs = append(errs, err)
-				continue
+				if !keepsSource(err) {
+					continue
+				}
 			}
 
 			track[path] = &Change{
@@ -104,14 +265,23 @@ func (b *Bundle) Reload() ([]*Change, error) {
 					unchecked = append(unchecked, dep)
 				}
 			}
+			for _, asset := range source.Assets {
+				if !checked[asset] {
+					unchecked = append(unchecked, asset)
+				}
+			}
 			continue
 		}
 
-		changed, next, err := b.ReloadSource(info.Prev)
+		retry := info.Prev.unresolved && retryProviders
+		changed, next, err := false, info.Prev, error(nil)
+		if modified == nil || modified[path] || info.Prev.dynamic || retry {
+			changed, next, err = b.reloadSource(info.Prev, retry)
+		}
 		if next == nil {
 			continue
 		}
-		if err != nil {
+		if err != nil && err != ErrUnknownImport {
 			errs = append(errs, err)
 		}
 
@@ -120,6 +290,11 @@ func (b *Bundle) Reload() ([]*Change, error) {
 				unchecked = append(unchecked, dep)
 			}
 		}
+		for _, asset := range next.Assets {
+			if !checked[asset] {
+				unchecked = append(unchecked, asset)
+			}
+		}
 
 		if changed {
 			info.Next = next
@@ -129,6 +304,8 @@ func (b *Bundle) Reload() ([]*Change, error) {
 		}
 	}
 
+	b.wrapRequired(track)
+
 	sources := []*Source{}
 	changes := []*Change{}
 	for _, info := range track {
@@ -141,17 +318,25 @@ func (b *Bundle) Reload() ([]*Change, error) {
 		}
 	}
 	if len(changes) == 0 {
-		return []*Change{}, errs.Nilify()
+		// sources are the same, so are the errors from sorting them
+		errs = append(errs, snap.errs...)
+		return snap, []*Change{}, errs.Nilify()
 	}
 
-	sorted, err := SortSources(sources)
+	sortErrs := Errors{}
+	sorted, err := SortSources(sources, b.Main...)
 	if err != nil {
-		errs = append(errs, err)
+		b.annotateMissing(err)
+		sortErrs = append(sortErrs, err)
 	}
+	sortErrs = append(sortErrs, b.checkCase(sorted)...)
+	errs = append(errs, sortErrs...)
 
-	b.sources.Store(sorted)
+	next := newSnapshot(snap.Generation+1, sorted, sortErrs)
+	b.snapshot.Store(next)
+	sortChanges(changes, sorted)
 
-	return changes, errs.Nilify()
+	return next, changes, errs.Nilify()
 }
 
 // Load loads a source from path
@@ -161,8 +346,17 @@ func (b *Bundle) Load(path string) (*Source, error) {
 }
 
 // ReloadSource reloads the base file and returns a new Source file in next.
-// If file doesn't exist any more it will return nil as next
+// If file doesn't exist any more it will return nil as next.
+// Sources with glob or directory dependencies are always reloaded,
+// changed is true when their list of dependencies changes.
+// When a processor fails, next keeps the last good output of prev.
 func (b *Bundle) ReloadSource(prev *Source) (changed bool, next *Source, err error) {
+	return b.reloadSource(prev, false)
+}
+
+// reloadSource implements ReloadSource, force reads the file
+// even when it hasn't been modified
+func (b *Bundle) reloadSource(prev *Source, force bool) (changed bool, next *Source, err error) {
 	file, err := b.Root.Open(prev.Path)
 	if err != nil {
 		return true, nil, err
@@ -175,26 +369,142 @@ func (b *Bundle) ReloadSource(prev *Source) (changed bool, next *Source, err err
 		Ext:  ext,
 
 		ContentType: mime.TypeByExtension(ext),
+
+		aliases: b.Aliases,
 	}
 
 	stat, staterr := file.Stat()
 	if staterr == nil {
 		next.ModTime = stat.ModTime()
-		if next.ModTime.Equal(prev.ModTime) {
+		if next.ModTime.Equal(prev.ModTime) && !prev.dynamic && !force {
 			next.Content = prev.Content
 			next.Processed = prev.Processed
+			next.Map = prev.Map
 
 			return false, next, nil
 		}
 	} else {
 		next.ModTime = time.Now()
 	}
-	if err = next.ReadFrom(file); err != nil {
-
+	err = next.ReadFrom(file)
+	if err != nil && err != ErrUnknownImport {
 		return true, next, err
 	}
+	if rerr := b.resolve(next); rerr != nil {
+		err = rerr
+	}
+	changed = !bytes.Equal(prev.Content, next.Content) || !sameDeps(prev.Deps, next.Deps)
+
+	if perr := b.process(next); perr != nil {
+		// keep the last good output
+		next.Processed, next.Map = next.Content, nil
+		if prev.Processed != nil {
+			next.Processed, next.Map = prev.Processed, prev.Map
+		}
+		return changed, next, perr
+	}
 
-	return !bytes.Equal(prev.Content, next.Content), next, nil
+	return changed, next, err
+}
+
+// resolve resolves imports of src with the Aliases and the Resolver,
+// imports that cannot be resolved keep the path relative to src.
+// Glob patterns are expanded against Root and package names are resolved
+// to the files that declare them.
+func (b *Bundle) resolve(src *Source) error {
+	var errs Errors
+
+	resolver := b.Resolver
+	if resolver == nil {
+		resolver = NodeResolver{}
+	}
+
+	imports := []Import{}
+	src.Deps = []string{}
+	src.patterns = nil
+	for _, imp := range src.Imports {
+		spec := b.alias(imp.Spec)
+		if isDynamicSpec(spec) {
+			src.dynamic = true
+			src.patterns = append(src.patterns, dynamicPattern(src.Path, spec))
+		}
+		if isGlob(spec) {
+			// every matching file becomes a separate import
+			for _, match := range globFiles(b.Root, resolvePath(src.Path, spec)) {
+				if match != src.Path {
+					imp.Path = match
+					imports = append(imports, imp)
+					src.Deps = appendUnique(src.Deps, imp.Path)
+				}
+			}
+			continue
+		}
+
+		if isPackageName(spec) {
+			providers := b.providers.lookup(b.Root, spec)
+			for i, provider := range providers {
+				if provider == src.Path {
+					providers = append(providers[:i], providers[i+1:]...)
+					break
+				}
+			}
+
+			switch {
+			case len(providers) == 1:
+				imp.Path = providers[0]
+				imports = append(imports, imp)
+				src.Deps = appendUnique(src.Deps, imp.Path)
+				continue
+			case len(providers) > 1:
+				errs = append(errs, &ProviderError{Pos: imp.Pos(src.Path), Name: spec, Providers: providers})
+				src.unresolved = true
+				continue
+			}
+		}
+
+		resolved, err := resolver.Resolve(b.Root, src, spec)
+		switch {
+		case err == nil:
+			imp.Path = resolved
+		case imp.bare:
+			// the browser may resolve it, e.g. with an import map
+			continue
+		case isPackageName(spec) && strings.Contains(spec, "."):
+			// neither a package declared with package(...) nor a file
+			errs = append(errs, &ProviderError{Pos: imp.Pos(src.Path), Name: spec})
+			src.unresolved = true
+			continue
+		case spec != imp.Spec:
+			imp.Path = resolvePath(src.Path, spec)
+		}
+		imports = append(imports, imp)
+		src.Deps = appendUnique(src.Deps, imp.Path)
+	}
+	src.Imports = imports
+	return errs.Nilify()
+}
+
+// alias replaces the longest matching alias prefix in spec
+func (b *Bundle) alias(spec string) string { return aliasSpec(b.Aliases, spec) }
+
+// aliasSpec replaces the longest matching prefix of aliases in spec
+func aliasSpec(aliases map[string]string, spec string) string {
+	prefix := ""
+	for from := range aliases {
+		if len(from) > len(prefix) && strings.HasPrefix(spec, from) {
+			prefix = from
+		}
+	}
+	if prefix == "" {
+		return spec
+	}
+	return aliases[prefix] + spec[len(prefix):]
+}
+
+// isAliased returns whether spec starts with one of the prefixes in aliases,
+// such specs are dependencies even when they look like URLs or bare names
+func isAliased(aliases map[string]string, spec string) bool {
+	return aliasSpec(aliases, spec) != spec
 }
 
 // All returns the list of sorted sources
@@ -210,35 +520,24 @@ func (b *Bundle) All() []*Source {
 // All returns sorted sources with specified ext
 // Do not modify this list!
 func (b *Bundle) ByExt(ext string) []*Source {
-	byext := []*Source{}
-	for _, src := range b.current() {
-		if src.Ext == ext {
-			byext = append(byext, src)
-		}
+	byext := b.Snapshot().ByExt(ext)
+	if byext == nil {
+		return []*Source{}
 	}
 	return byext
 }
 
-// MergedByExt bundles files together into bytes by ext
+// MergedByExt bundles files together into bytes by ext.
+// Relative references in stylesheets are rebased to the bundle location.
 func (b *Bundle) MergedByExt(ext string) []byte {
-	var buf bytes.Buffer
-	for _, src := range b.ByExt(ext) {
-		fmt.Fprintf(&buf, "\n/* \"%s\" */\n", src.Path)
-		buf.Write(src.Processed)
-		buf.WriteByte('\n')
-	}
-
-	return buf.Bytes()
+	return b.merge(b.ByExt(ext), ext, "").Content
 }
 
 // fromCache loads path from cache if it exists, otherwise loads from Root
 func (b *Bundle) fromCache(path string) (*Source, error) {
-	for _, src := range b.current() {
-		if src.Path == path {
-			return src, nil
-		}
+	if src := b.Snapshot().Source(path); src != nil {
+		return src, nil
 	}
-
 	return b.Load(path)
 }
 
@@ -262,9 +561,56 @@ func (b *Bundle) ServeFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", src.ContentType)
+	if src.Ext == ".css" && len(src.Assets) > 0 {
+		w.Write(b.versionAssets(src))
+		return
+	}
 	w.Write(src.Processed)
 }
 
+// versionAssets adds modification time to asset references in a stylesheet,
+// so that the browser refetches assets when the stylesheet is reloaded
+func (b *Bundle) versionAssets(src *Source) []byte {
+	modified := make(map[string]time.Time, len(src.Assets))
+	for _, asset := range src.Assets {
+		modified[asset] = time.Time{}
+	}
+	snap := b.Snapshot()
+	for _, asset := range src.Assets {
+		if loaded := snap.Source(asset); loaded != nil {
+			modified[asset] = loaded.ModTime
+		}
+	}
+
+	return cssRewriteURLs(src.Processed, func(ref string) string {
+		if !isLocal(ref) || strings.ContainsAny(ref, "?#") {
+			return ref
+		}
+		modtime, ok := modified[resolvePath(src.Path, ref)]
+		if !ok || modtime.IsZero() {
+			return ref
+		}
+		return ref + "?" + strconv.FormatInt(modtime.UnixNano(), 36)
+	})
+}
+
+// keepsSource returns whether a source that was loaded with err
+// can still be used
+func keepsSource(err error) bool {
+	switch err := err.(type) {
+	case *ProcessError, *ProviderError:
+		return true
+	case Errors:
+		for _, err := range err {
+			if !keepsSource(err) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // sameDeps returns true if the dependencies are the same
 func sameDeps(a, b []string) bool {
 	if len(a) != len(b) {
@@ -2,10 +2,19 @@ package livepkg
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -65,77 +74,1385 @@ func TestLoadCycle(t *testing.T) {
 	}
 }
 
+func TestLoadDeepChain(t *testing.T) {
+	fs := filesystem{"/300.js": ``}
+	expected := []string{"/300.js"}
+	for i := 299; i >= 0; i-- {
+		fs[fmt.Sprintf("/%d.js", i)] = fmt.Sprintf(`depends("%d.js")`, i+1)
+		expected = append(expected, fmt.Sprintf("/%d.js", i))
+	}
+
+	bundle := NewBundle(fs, "/0.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err %v", err)
+	}
+	if !sameFiles(bundle.All(), expected) {
+		t.Errorf("got %v", names(bundle.All()))
+	}
+}
+
+func TestSortDeterministic(t *testing.T) {
+	sources := []*Source{
+		{Path: "/z.js"},
+		{Path: "/b.js"},
+		{Path: "/main.js", Deps: []string{"/lib/y.js", "/lib/x.js"}},
+		{Path: "/lib/x.js"},
+		{Path: "/lib/y.js", Deps: []string{"/lib/x.js"}},
+		{Path: "/a.js"},
+	}
+	expected := []string{"/lib/x.js", "/lib/y.js", "/main.js", "/z.js", "/a.js", "/b.js"}
+
+	for i := 0; i < 10; i++ {
+		rand.Shuffle(len(sources), func(i, k int) { sources[i], sources[k] = sources[k], sources[i] })
+		sorted, err := SortSources(sources, "/main.js", "/z.js")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameFiles(sorted, expected) {
+			t.Fatalf("got %v", names(sorted))
+		}
+	}
+}
+
+func TestLoadMissingPosition(t *testing.T) {
+	fs := filesystem{
+		"/main.js": "\n  depends(\"missing.js\")",
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+	if err == nil || !strings.Contains(err.Error(), "/main.js:2:3: /missing.js") {
+		t.Errorf("should have gotten an error with position, got %v", err)
+	}
+}
+
+func TestCycleError(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("a.js")`,
+		"/a.js":    `depends("b.js")`,
+		"/b.js":    "\n" + `depends("a.js")`,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("expected *CycleError, got %v", err)
+	}
+	if len(cycle.Cycles) != 1 {
+		t.Fatalf("expected a single cycle, got %+v", cycle.Cycles)
+	}
+	expected := []Edge{
+		{Path: "/a.js", Dep: "/b.js", Pos: "/a.js:1:1"},
+		{Path: "/b.js", Dep: "/a.js", Pos: "/b.js:2:1"},
+	}
+	if !reflect.DeepEqual(cycle.Cycles[0].Edges, expected) {
+		t.Errorf("got %+v", cycle.Cycles[0].Edges)
+	}
+	if !strings.Contains(err.Error(), "/b.js:2:1") {
+		t.Errorf("error should contain position, got %v", err)
+	}
+
+	// the error is reported until the cycle is fixed
+	_, err = bundle.Reload()
+	if !errors.As(err, &cycle) {
+		t.Errorf("expected *CycleError on reload, got %v", err)
+	}
+}
+
+func TestMissingDependencyError(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("a.js");` + "\n" + `depends("missing.js")`,
+		"/a.js":    ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+
+	var missing *MissingDependencyError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected *MissingDependencyError, got %v", err)
+	}
+	expected := MissingDependencyError{Importer: "/main.js", Path: "/missing.js", Pos: "/main.js:2:1"}
+	if !reflect.DeepEqual(*missing, expected) {
+		t.Errorf("got %+v", *missing)
+	}
+}
+
+func TestMissingDependencySuggestions(t *testing.T) {
+	fs := filesystem{
+		"/main.js":         `depends("ui/wandrer.js");` + "\n" + `depends("ui/Render.js");` + "\n" + `depends("ui/style.css");`,
+		"/ui/wanderer.js":  ``,
+		"/ui/render.js":    ``,
+		"/ui/style.scss":   ``,
+		"/ui/unrelated.js": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+
+	found := map[string]MissingDependencyError{}
+	eachError(err, func(err error) {
+		if missing, ok := err.(*MissingDependencyError); ok {
+			found[missing.Path] = *missing
+		}
+	})
+
+	if got := found["/ui/wandrer.js"].Suggestions; !reflect.DeepEqual(got, []string{"/ui/wanderer.js"}) {
+		t.Errorf("wandrer.js: got %v", got)
+	}
+	if got := found["/ui/Render.js"].CaseMismatch; got != "/ui/render.js" {
+		t.Errorf("Render.js: got case mismatch %q", got)
+	}
+	if got := found["/ui/style.css"].Suggestions; !reflect.DeepEqual(got, []string{"/ui/style.scss"}) {
+		t.Errorf("style.css: got %v", got)
+	}
+	if !strings.Contains(err.Error(), "/main.js:1:1: /ui/wandrer.js is missing, did you mean /ui/wanderer.js?") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCaseInsensitiveMismatch(t *testing.T) {
+	fs := foldFilesystem{filesystem{
+		"/main.js":         `depends("ui/Wanderer.js")`,
+		"/ui/wanderer.js":  ``,
+		"/ui/unrelated.js": ``,
+	}}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+
+	var missing *MissingDependencyError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected *MissingDependencyError, got %v", err)
+	}
+	if missing.Path != "/ui/Wanderer.js" || missing.CaseMismatch != "/ui/wanderer.js" {
+		t.Errorf("got %+v", *missing)
+	}
+}
+
+func TestCaseUnrootedMain(t *testing.T) {
+	root, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for name, data := range map[string]string{
+		"main.js": `depends("a.js")`,
+		"a.js":    ``,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bundle := NewBundle(http.Dir(root), "main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
 func TestReloadChangeFile(t *testing.T) {
 	fs := filesystem{"/main.js": ``}
 
 	bundle := NewBundle(fs, "/main.js")
 	_, err := bundle.Reload()
-	if err != nil {
-		t.Errorf("err initial load: %v", err)
+	if err != nil {
+		t.Errorf("err initial load: %v", err)
+	}
+
+	fs["/main.js"] = `CONTENT`
+	changes, err := bundle.Reload()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+
+	if len(changes) != 1 {
+		t.Errorf("invalid number of changes: %#v", changes)
+		return
+	}
+
+	if !(changes[0].Prev != nil &&
+		bytes.Equal(changes[0].Prev.Content, []byte(``)) &&
+		changes[0].Next != nil &&
+		bytes.Equal(changes[0].Next.Content, []byte(`CONTENT`)) &&
+		changes[0].Deps == false) {
+		t.Errorf("should've detected file modification")
+	}
+
+	fs["/main.js"] = `depends("/other.js")`
+	fs["/other.js"] = ``
+
+	changes, err = bundle.Reload()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+	sort.Sort(changesByPath(changes))
+
+	if len(changes) != 2 {
+		t.Errorf("invalid number of changes after deps change: %#v", changes)
+		return
+	}
+
+	if !(changes[0].Deps == true) {
+		t.Errorf("should've detected file modification %v", changes)
+	}
+
+	if !(changes[1].Prev == nil && changes[1].Next != nil &&
+		changes[1].Next.Path == `/other.js`) {
+		t.Errorf("should've loaded new file")
+	}
+
+	delete(fs, "/other.js")
+
+	changes, _ = bundle.Reload()
+
+	if len(changes) != 1 {
+		t.Errorf("invalid number of changes after file delete: %#v", changes)
+		return
+	}
+	if !(changes[0].Next == nil) {
+		t.Errorf("should've detected file deletion %#v", changes[0])
+	}
+
+	fs["/other.js"] = `blah`
+	changes, _ = bundle.Reload()
+	if len(changes) != 1 {
+		t.Errorf("invalid number of changes after file re-add: %#v", changes)
+		return
+	}
+	if !(changes[0].Next != nil) {
+		t.Errorf("should've detected file adding %#v", changes[0])
+	}
+}
+
+func TestReloadFiles(t *testing.T) {
+	fs := filesystem{
+		"/main.js":  `depends("a.js"); depends("b.js"); depends("dir/*.js")`,
+		"/a.js":     `A`,
+		"/b.js":     `B`,
+		"/dir/x.js": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err initial load: %v", err)
+	}
+
+	fs["/a.js"] = `A2`
+	fs["/b.js"] = `B2`
+	fs["/dir/y.js"] = ``
+	changes, err := bundle.ReloadFiles("/a.js")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	sort.Sort(changesByPath(changes))
+
+	// b.js is not reloaded, main.js is reloaded because of the glob
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, change.Next.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"/a.js", "/dir/y.js", "/main.js"}) {
+		t.Errorf("got changes %v", paths)
+	}
+	if content := string(find(bundle.All(), "/b.js").Content); content != `B` {
+		t.Errorf("b.js should not have been reloaded, got %q", content)
+	}
+}
+
+func TestReloadChangesSorted(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("b.js")`,
+		"/b.js":    `depends("a.js")`,
+		"/a.js":    ``,
+		"/old.js":  ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js", "/old.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err initial load: %v", err)
+	}
+
+	fs["/main.js"] += ` `
+	fs["/b.js"] += ` `
+	fs["/a.js"] += ` `
+	delete(fs, "/old.js")
+	changes, _ := bundle.Reload()
+
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, change.path())
+	}
+	if !reflect.DeepEqual(paths, []string{"/old.js", "/a.js", "/b.js", "/main.js"}) {
+		t.Errorf("got %v", paths)
+	}
+}
+
+func TestMergeChanges(t *testing.T) {
+	a1 := &Source{Path: "/a.js", Content: []byte("1")}
+	a2 := &Source{Path: "/a.js", Content: []byte("2")}
+	a3 := &Source{Path: "/a.js", Content: []byte("3")}
+	b := &Source{Path: "/b.js"}
+	c := &Source{Path: "/c.js"}
+
+	merged := mergeChanges(
+		[]*Change{{Prev: a1, Next: a2}, {Next: b}},
+		[]*Change{{Prev: a2, Next: a3, Deps: true}, {Prev: b}, {Next: c}},
+	)
+
+	expected := []*Change{{Prev: a1, Next: a3, Deps: true}, {Next: c}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("got %+v", merged)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fs := filesystem{
+		"/main.js":   `depends("a.js"); depends("b.js"); depends("style.css")`,
+		"/a.js":      `depends("b.js")`,
+		"/b.js":      ``,
+		"/style.css": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if bundle.Snapshot().Generation != 0 {
+		t.Errorf("empty bundle should have generation 0")
+	}
+	snap, _, err := bundle.ReloadSnapshot()
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	if snap != bundle.Snapshot() || snap.Generation != 1 {
+		t.Errorf("expected current snapshot with generation 1, got %d", snap.Generation)
+	}
+
+	if src := snap.Source("/a.js"); src == nil || src.Path != "/a.js" {
+		t.Errorf("lookup failed: %v", src)
+	}
+	if src := snap.Source("/missing.js"); src != nil {
+		t.Errorf("lookup of missing file: %v", src)
+	}
+	if got := names(snap.Deps("/main.js")); !reflect.DeepEqual(got, []string{"/a.js", "/b.js", "/style.css"}) {
+		t.Errorf("deps: %v", got)
+	}
+	if got := names(snap.Dependents("/b.js")); !reflect.DeepEqual(got, []string{"/a.js", "/main.js"}) {
+		t.Errorf("dependents: %v", got)
+	}
+	if got := names(snap.ByExt(".js")); !reflect.DeepEqual(got, []string{"/b.js", "/a.js", "/main.js"}) {
+		t.Errorf("by ext: %v", got)
+	}
+
+	unchanged, changes, _ := bundle.ReloadSnapshot()
+	if unchanged != snap || len(changes) != 0 {
+		t.Errorf("snapshot should stay the same without changes")
+	}
+
+	fs["/b.js"] = `B`
+	next, _, _ := bundle.ReloadSnapshot()
+	if next.Generation != 2 {
+		t.Errorf("expected generation 2, got %d", next.Generation)
+	}
+	if string(snap.Source("/b.js").Content) != `` {
+		t.Errorf("previous snapshot was modified")
+	}
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	fs := &syncFilesystem{fs: filesystem{
+		"/main.js": `depends("a.js"); depends("b.js")`,
+		"/a.js":    `0`,
+		"/b.js":    `0`,
+	}}
+
+	bundle := NewBundle(fs, "/main.js")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				bundle.Reload()
+				bundle.All()
+				bundle.Merged(".js")
+			}
+		}()
+	}
+	for k := 1; k <= 20; k++ {
+		fs.set("/a.js", strconv.Itoa(k))
+		fs.set("/b.js", strconv.Itoa(k))
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err %v", err)
+	}
+	for _, path := range []string{"/a.js", "/b.js"} {
+		if content := string(bundle.Snapshot().Source(path).Content); content != "20" {
+			t.Errorf("%s: lost change, got %q", path, content)
+		}
+	}
+}
+
+func TestReloadSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	fs := &syncFilesystem{fs: filesystem{"/main.js": ``}}
+	fs.block = func(name string) {
+		if name == "/main.js" {
+			<-release
+		}
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+
+	results := make(chan []*Change, 4)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			changes, _ := bundle.Reload()
+			results <- changes
+		}()
+	}
+	// let all the calls start before the first completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	first := <-results
+	if len(first) != 1 {
+		t.Fatalf("expected a single change, got %v", first)
+	}
+	for i := 1; i < cap(results); i++ {
+		changes := <-results
+		if len(changes) != 1 || changes[0] != first[0] {
+			t.Errorf("calls should share the same changes, got %v", changes)
+		}
+	}
+	if opens := fs.opens("/main.js"); opens != 1 {
+		t.Errorf("expected a single reload, main.js was opened %d times", opens)
+	}
+}
+
+func TestImpact(t *testing.T) {
+	fs := filesystem{
+		"/main.js":    `depends("alpha.js"); depends("beta/x.js")`,
+		"/admin.js":   `depends("alpha.js")`,
+		"/alpha.js":   `depends("beta/x.js")`,
+		"/beta/x.js":  `depends("y.js")`,
+		"/beta/y.js":  ``,
+		"/unused.css": ``,
+		"/style.css":  `body { background: url(img/bg.png) }`,
+		"/img/bg.png": `PNG`,
+	}
+
+	bundle := NewBundle(fs, "/main.js", "/admin.js", "/unused.css", "/style.css")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err %v", err)
+	}
+
+	if got := names(bundle.Dependents("/beta/x.js")); !reflect.DeepEqual(got, []string{"/alpha.js", "/main.js"}) {
+		t.Errorf("dependents: %v", got)
+	}
+	if got := names(bundle.TransitiveDependents("/beta/y.js")); !reflect.DeepEqual(got, []string{"/beta/x.js", "/alpha.js", "/main.js", "/admin.js"}) {
+		t.Errorf("transitive dependents: %v", got)
+	}
+	if got := bundle.Chain("/beta/y.js"); !reflect.DeepEqual(got, []string{"/main.js", "/beta/x.js", "/beta/y.js"}) {
+		t.Errorf("chain: %v", got)
+	}
+	if got := bundle.Chain("/admin.js"); !reflect.DeepEqual(got, []string{"/admin.js"}) {
+		t.Errorf("chain of main: %v", got)
+	}
+	if got := bundle.Chain("/missing.js"); got != nil {
+		t.Errorf("chain of missing: %v", got)
+	}
+
+	fs["/alpha.js"] += ` `
+	changes, _ := bundle.Reload()
+	if len(changes) != 1 {
+		t.Fatalf("expected a single change, got %v", changes)
+	}
+	if got := bundle.AffectedMains(changes[0]); !reflect.DeepEqual(got, []string{"/main.js", "/admin.js"}) {
+		t.Errorf("affected mains: %v", got)
+	}
+	if got := bundle.Impact("/unused.css").Mains; !reflect.DeepEqual(got, []string{"/unused.css"}) {
+		t.Errorf("affected mains of main: %v", got)
+	}
+
+	impact := bundle.Impact("/img/bg.png")
+	if !reflect.DeepEqual(impact.Dependents, []string{"/style.css"}) ||
+		!reflect.DeepEqual(impact.Chain, []string{"/style.css", "/img/bg.png"}) ||
+		!reflect.DeepEqual(impact.Mains, []string{"/style.css"}) {
+		t.Errorf("impact of asset: %+v", impact)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	fs := filesystem{
+		"/main.js":   `depends("a.js"); depends("style.css"); depends("missing.js")`,
+		"/a.js":      `depends("b.js")`,
+		"/b.js":      `depends("a.js")`,
+		"/style.css": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	bundle.Reload()
+	graph := bundle.Graph()
+
+	nodes := map[string]GraphNode{}
+	for _, node := range graph.Nodes {
+		nodes[node.Path] = node
+	}
+	if len(nodes) != 5 || !nodes["/missing.js"].Missing || !nodes["/a.js"].Cycle || !nodes["/b.js"].Cycle || nodes["/main.js"].Cycle {
+		t.Errorf("invalid nodes %+v", graph.Nodes)
+	}
+	cycles := 0
+	for _, edge := range graph.Edges {
+		if edge.Cycle {
+			cycles++
+		}
+	}
+	if len(graph.Edges) != 5 || cycles != 2 {
+		t.Errorf("invalid edges %+v", graph.Edges)
+	}
+
+	var dot bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"/missing.js" [style=dashed];`,
+		`"/a.js" -> "/b.js" [color="#d62728", penwidth=2];`,
+		`"/main.js" -> "/style.css" [color="#264de4"];`,
+	} {
+		if !strings.Contains(dot.String(), expected) {
+			t.Errorf("DOT doesn't contain %s:\n%s", expected, dot.String())
+		}
+	}
+
+	var mermaid bytes.Buffer
+	if err := graph.WriteMermaid(&mermaid); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mermaid.String(), "graph LR\n") || !strings.Contains(mermaid.String(), `["/missing.js"]:::missing`) {
+		t.Errorf("invalid Mermaid:\n%s", mermaid.String())
+	}
+
+	var data bytes.Buffer
+	if err := graph.WriteJSON(&data); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Graph{}
+	if err := json.Unmarshal(data.Bytes(), decoded); err != nil || !reflect.DeepEqual(decoded, graph) {
+		t.Errorf("JSON round trip failed: %v", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	fs := filesystem{
+		"/main.js":         `depends("lib.js"); depends("main.css")`,
+		"/lib.js":          `var lib = 1;`,
+		"/main.css":        `body { background: url(img/bg.png); }`,
+		"/img/bg.png":      `PNG`,
+		"/unreferenced.js": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := bundle.Build(filepath.Join(dir, "dist")); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "dist", filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		return string(data)
+	}
+	if js := read("~pkg.js"); !strings.HasPrefix(js, jspackage) || !strings.Contains(js, `var lib = 1;`) {
+		t.Errorf("invalid ~pkg.js:\n%s", js)
+	}
+	if css := read("~pkg.css"); !strings.Contains(css, `img/bg.png`) {
+		t.Errorf("invalid ~pkg.css:\n%s", css)
+	}
+	for _, name := range []string{"~pkg.js.map", "~pkg.css.map"} {
+		smap := &SourceMap{}
+		if err := json.Unmarshal([]byte(read(name)), smap); err != nil || smap.Version != 3 {
+			t.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	if png := read("img/bg.png"); png != `PNG` {
+		t.Errorf("asset not copied, got %q", png)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dist", "unreferenced.js")); err == nil {
+		t.Errorf("unreferenced file should not be copied")
+	}
+}
+
+func TestBuildModules(t *testing.T) {
+	fs := filesystem{
+		"/main.js":   `depends("lib.js"); depends("app.js");`,
+		"/lib.js":    `var lib = 1;`,
+		"/app.js":    `export const app = 1;`,
+		"/style.css": ``,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatalf("err %v", err)
+	}
+
+	if js := string(bundle.Merged(".js").Content); strings.Contains(js, "export const") || !strings.Contains(js, "var lib = 1;") {
+		t.Errorf("modules should be left out of the bundle:\n%s", js)
+	}
+
+	var modules *ModuleError
+	err := bundle.Build(filepath.Join(os.TempDir(), "livepkg-modules"))
+	if !errors.As(err, &modules) || !reflect.DeepEqual(modules.Paths, []string{"/app.js"}) {
+		t.Errorf("expected *ModuleError, got %v", err)
+	}
+}
+
+func TestBuildInsideRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for name, data := range map[string]string{
+		"main.js": `depends("ui");`,
+		"ui.js":   `package("ui", function(ui){});`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the output of the first build must not provide packages for the second
+	for i := 0; i < 2; i++ {
+		bundle := NewBundle(http.Dir(root), "/main.js")
+		if _, err := bundle.Reload(); err != nil {
+			t.Fatalf("build %d: %v", i, err)
+		}
+		if deps := find(bundle.All(), "/main.js").Deps; !sameDeps(deps, []string{"/ui.js"}) {
+			t.Errorf("build %d: got deps %v", i, deps)
+		}
+		if err := bundle.Build(filepath.Join(root, "dist")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReloadChangeAsset(t *testing.T) {
+	fs := filesystem{
+		"/main.css":   `body { background: url(img/bg.png) }`,
+		"/img/bg.png": `PNG`,
+	}
+
+	bundle := NewBundle(fs, "/main.css")
+	_, err := bundle.Reload()
+	if err != nil {
+		t.Errorf("err initial load: %v", err)
+	}
+
+	fs["/img/bg.png"] = `PNG2`
+	changes, err := bundle.Reload()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+
+	if len(changes) != 1 {
+		t.Errorf("invalid number of changes: %#v", changes)
+		return
+	}
+	if changes[0].Next == nil || changes[0].Next.Path != "/img/bg.png" || changes[0].Deps {
+		t.Errorf("should've detected asset modification %#v", changes[0])
+	}
+}
+
+func TestMergeCSSRebasesURLs(t *testing.T) {
+	fs := filesystem{
+		"/main.css":        `@import "styles/a.css"; @import "https://example.com/x.css"; @depends "/reset.css";`,
+		"/reset.css":       `body { background: url("/img/abs.png") }`,
+		"/styles/a.css":    `.a { background: url(../img/a.png?v=1) } .b { background: url(b.png#x) } .c { background: url('q"\\x.png') }`,
+		"/img/a.png":       ``,
+		"/styles/b.png":    ``,
+		"/img/abs.png":     ``,
+		`/styles/q"\x.png`: ``,
+	}
+
+	bundle := NewBundle(fs, "/main.css")
+	_, err := bundle.Reload()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+
+	merged := string(bundle.MergedByExt(".css"))
+	for _, expect := range []string{
+		`url("/img/abs.png")`,
+		`url("img/a.png?v=1")`,
+		`url("styles/b.png#x")`,
+		`url("styles/q\22 \5c x.png")`,
+		`@import "https://example.com/x.css";`,
+	} {
+		if !strings.Contains(merged, expect) {
+			t.Errorf("expected %s in merged:\n%s", expect, merged)
+		}
+	}
+	if strings.Contains(merged, `styles/a.css`+`";`) {
+		t.Errorf("bundled @import should be removed:\n%s", merged)
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	fs := filesystem{"/main.js": `a`}
+
+	bundle := NewBundle(fs, "/main.js")
+	bundle.AddProcessor(".js",
+		ProcessorFunc(func(src *Source) error {
+			if bytes.Contains(src.Processed, []byte("FAIL")) {
+				return errors.New("failed")
+			}
+			src.Processed = append([]byte("1"), src.Processed...)
+			return nil
+		}),
+		ProcessorFunc(func(src *Source) error {
+			src.Processed = append([]byte("2"), src.Processed...)
+			return nil
+		}),
+	)
+
+	_, err := bundle.Reload()
+	if err != nil {
+		t.Errorf("err initial load: %v", err)
+	}
+	if got := string(bundle.All()[0].Processed); got != "21a" {
+		t.Errorf("got %q", got)
+	}
+
+	fs["/main.js"] = `FAIL`
+	changes, err := bundle.Reload()
+	if err == nil {
+		t.Errorf("expected processor error")
+	}
+	if len(changes) != 1 {
+		t.Errorf("invalid number of changes: %#v", changes)
+		return
+	}
+	next := changes[0].Next
+	if string(next.Content) != "FAIL" || string(next.Processed) != "21a" {
+		t.Errorf("should keep last good output, got %q", next.Processed)
+	}
+}
+
+func TestCommonJS(t *testing.T) {
+	fs := filesystem{
+		"/main.js":     `var math = require("./lib/math");` + "\n" + `console.log(math.add(1, 2));`,
+		"/lib/math.js": `exports.add = function(a, b){ return a + b; };`,
+		"/umd.js":      `if(typeof module === "object") module.exports = {}; else window.x = {};`,
+	}
+
+	bundle := NewBundle(fs, "/main.js", "/umd.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	all := bundle.All()
+	main, math, umd := find(all, "/main.js"), find(all, "/lib/math.js"), find(all, "/umd.js")
+	if math == nil {
+		t.Fatalf("required file not loaded: %v", names(bundle.All()))
+	}
+	if !main.CommonJS || !math.CommonJS || umd.CommonJS {
+		t.Errorf("invalid CommonJS detection")
+	}
+
+	expected := `package.define("/main.js", {"./lib/math":"/lib/math.js"}, function(require, module, exports){` +
+		string(main.Content) + "\n});"
+	if string(main.Processed) != expected {
+		t.Errorf("got %q", main.Processed)
+	}
+	if !bytes.Equal(umd.Processed, umd.Content) {
+		t.Errorf("UMD should not be wrapped, got %q", umd.Processed)
+	}
+}
+
+func TestCommonJSRequiresUMD(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `var lib = require("./lib");`,
+		"/lib.js": `(function(root, factory){
+	if(typeof module === "object" && module.exports) module.exports = factory();
+	else root.lib = factory();
+})(this, function(){ return {}; });`,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	lib := find(bundle.All(), "/lib.js")
+	if !lib.UMD || !bytes.HasPrefix(lib.Processed, []byte(`package.define("/lib.js"`)) {
+		t.Fatalf("required UMD should be wrapped, got %q", lib.Processed)
+	}
+
+	// no longer required
+	fs["/main.js"] = `depends("lib.js");`
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if lib := find(bundle.All(), "/lib.js"); !bytes.Equal(lib.Processed, lib.Content) {
+		t.Errorf("UMD should not be wrapped, got %q", lib.Processed)
+	}
+}
+
+func TestNodeResolver(t *testing.T) {
+	fs := filesystem{
+		"/app/main.js": `depends("lodash"); depends("local.js"); depends("./util");
+depends("@scope/pkg/feature"); depends("@scope/pkg"); depends("deep/x");
+depends("self"); depends("dot"); depends("loop"); depends("lodash.debounce");`,
+		"/app/local.js":      ``,
+		"/app/util/index.js": ``,
+
+		"/node_modules/lodash/package.json":   `{"main": "dist/lodash"}`,
+		"/node_modules/lodash/dist/lodash.js": ``,
+
+		"/node_modules/@scope/pkg/package.json": `{
+			"module": "esm.js",
+			"exports": {
+				".": {"import": "./esm.js", "require": "./cjs.js"},
+				"./*": {"default": "./lib/*.js"}
+			}
+		}`,
+		"/node_modules/@scope/pkg/cjs.js":         ``,
+		"/node_modules/@scope/pkg/lib/feature.js": ``,
+
+		"/app/node_modules/deep/x.js": ``,
+
+		"/node_modules/self/package.json":     `{"main": "./"}`,
+		"/node_modules/self/index.js":         ``,
+		"/node_modules/dot/package.json":      `{"main": "."}`,
+		"/node_modules/dot/index.js":          ``,
+		"/node_modules/loop/package.json":     `{"main": "sub"}`,
+		"/node_modules/loop/sub/package.json": `{"main": ".."}`,
+		"/node_modules/loop/index.js":         ``,
+
+		"/node_modules/lodash.debounce/index.js": ``,
+	}
+
+	bundle := NewBundle(fs, "/app/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	main := find(bundle.All(), "/app/main.js")
+	expected := []string{
+		"/node_modules/lodash/dist/lodash.js",
+		"/app/local.js",
+		"/app/util/index.js",
+		"/node_modules/@scope/pkg/lib/feature.js",
+		"/node_modules/@scope/pkg/cjs.js",
+		"/app/node_modules/deep/x.js",
+		"/node_modules/self/index.js",
+		"/node_modules/dot/index.js",
+		"/node_modules/loop/index.js",
+		"/node_modules/lodash.debounce/index.js",
+	}
+	if main == nil || !sameDeps(main.Deps, expected) {
+		t.Errorf("got %v", main)
+	}
+
+	bundle.Resolver = ResolverFunc(func(root http.FileSystem, from *Source, spec string) (string, error) {
+		return "", ErrNotResolved
+	})
+	fs["/app/main.js"] = `depends("lodash")`
+	bundle.Reload()
+	if deps := find(bundle.All(), "/app/main.js").Deps; !sameDeps(deps, []string{"/app/lodash"}) {
+		t.Errorf("unresolved dependency should be relative, got %v", deps)
+	}
+}
+
+func TestNodeResolverModules(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `import pkg from "@scope/pkg"; import "lodash-es"; import "./local.js";
+import x from "https://example.com/x.js"; import y from "from-import-map";`,
+		"/local.js": ``,
+
+		"/node_modules/@scope/pkg/package.json": `{"main": "cjs.js", "exports": {".": {"import": "./esm.js", "require": "./cjs.js"}}}`,
+		"/node_modules/@scope/pkg/esm.js":       `export default 1;`,
+		"/node_modules/@scope/pkg/cjs.js":       ``,
+		"/node_modules/lodash-es/package.json":  `{"main": "lodash.cjs", "module": "lodash.js"}`,
+		"/node_modules/lodash-es/lodash.js":     `export default 1;`,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// unresolved bare specifiers are left to the browser
+	expected := []string{"/node_modules/@scope/pkg/esm.js", "/node_modules/lodash-es/lodash.js", "/local.js"}
+	if deps := find(bundle.All(), "/main.js").Deps; !sameDeps(deps, expected) {
+		t.Errorf("got %v", deps)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	fs := filesystem{
+		"/app/main.js":           `depends("@shared/grid.js"); depends("@shared/ui/button.js"); depends("lib:util.js")`,
+		"/vendor/shared/grid.js": ``,
+		"/ui/button.js":          ``,
+		"/other/util.js":         ``,
+	}
+
+	bundle := NewBundle(fs, "/app/main.js")
+	bundle.Aliases = map[string]string{
+		"@shared/":    "/vendor/shared/",
+		"@shared/ui/": "/ui/",
+		"lib:":        "/other/",
+	}
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	main := find(bundle.All(), "/app/main.js")
+	if !sameDeps(main.Deps, []string{"/vendor/shared/grid.js", "/ui/button.js", "/other/util.js"}) {
+		t.Errorf("got %v", main.Deps)
+	}
+	if imp := main.ImportOf("/ui/button.js"); imp == nil || imp.Spec != "@shared/ui/button.js" {
+		t.Errorf("invalid import %v", imp)
+	}
+	if !sameFiles(bundle.All()[3:], []string{"/app/main.js"}) {
+		t.Errorf("got %v", names(bundle.All()))
+	}
+}
+
+func TestAliasesLookingLikeURLs(t *testing.T) {
+	fs := filesystem{
+		"/app/style.css":    `@import "lib:x.css"; body { background: url(lib:bg.png) }`,
+		"/app/module.js":    `import "lib:x.js"; export const y = 1;`,
+		"/app/common.js":    `var x = require("lib:x.js"); module.exports = x;`,
+		"/app/index.html":   `<script src="lib:x.js"></script><link rel="stylesheet" href="lib:x.css">`,
+		"/other/x.css":      ``,
+		"/other/x.js":       ``,
+		"/other/bg.png":     `PNG`,
+		"/app/unaliased.js": `import "http://example.com/x.js";`,
+	}
+
+	bundle := NewBundle(fs, "/app/style.css", "/app/module.js", "/app/common.js", "/app/index.html", "/app/unaliased.js")
+	bundle.Aliases = map[string]string{"lib:": "/other/"}
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"/app/style.css":    {"/other/x.css"},
+		"/app/module.js":    {"/other/x.js"},
+		"/app/common.js":    {"/other/x.js"},
+		"/app/index.html":   {"/other/x.js", "/other/x.css"},
+		"/app/unaliased.js": {},
+	}
+	for path, deps := range expected {
+		if got := find(bundle.All(), path).Deps; !sameDeps(got, deps) {
+			t.Errorf("%s: got %v, expected %v", path, got, deps)
+		}
+	}
+	if assets := find(bundle.All(), "/app/style.css").Assets; !sameDeps(assets, []string{"/other/bg.png"}) {
+		t.Errorf("got assets %v", assets)
+	}
+
+	css := string(bundle.Merged(".css").Content)
+	if strings.Contains(css, "@import") || !strings.Contains(css, `url("/other/bg.png")`) {
+		t.Errorf("aliases not replaced in merged css:\n%s", css)
+	}
+}
+
+func TestErrorInfos(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("a.js"); depends("missing.js")`,
+		"/a.js":    `depends("main.js")`,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
+
+	kinds := []string{}
+	for _, info := range errorInfos(err) {
+		kinds = append(kinds, info.Kind)
+	}
+	sort.Strings(kinds)
+	if !reflect.DeepEqual(kinds, []string{"cycle", "error", "missing"}) {
+		t.Errorf("got kinds %v", kinds)
 	}
+}
 
-	fs["/main.js"] = `CONTENT`
-	changes, err := bundle.Reload()
-	if err != nil {
-		t.Errorf("err %v", err)
+func TestAffects(t *testing.T) {
+	fs := filesystem{
+		"/main.js":      `depends("widgets/*.js"); depends("lib/"); depends("missing.js"); depends("ui.later")`,
+		"/widgets/a.js": ``,
+		"/lib/index.js": ``,
+		"/main.css":     `body { background: url(bg.png) }`,
 	}
 
-	if len(changes) != 1 {
-		t.Errorf("invalid number of changes: %#v", changes)
-		return
+	bundle := NewBundle(fs, "/main.js", "/main.css", "/other.css")
+	bundle.Reload()
+
+	for path, expected := range map[string]bool{
+		"":               true,
+		"/main.js":       true,
+		"/widgets/a.js":  true,
+		"/widgets/b.js":  true,
+		"/lib/other.js":  true,
+		"/missing.js":    true,
+		"/bg.png":        true,
+		"/other.css":     true,
+		"/later.js":      true,
+		"/package.json":  true,
+		"/widgets/x.css": false,
+		"/build.log":     false,
+		"/dist/main.css": false,
+	} {
+		if got := bundle.affects(path); got != expected {
+			t.Errorf("%q: got %v, expected %v", path, got, expected)
+		}
 	}
+}
 
-	if !(changes[0].Prev != nil &&
-		bytes.Equal(changes[0].Prev.Content, []byte(``)) &&
-		changes[0].Next != nil &&
-		bytes.Equal(changes[0].Next.Content, []byte(`CONTENT`)) &&
-		changes[0].Deps == false) {
-		t.Errorf("should've detected file modification")
+func TestServerWatchMaxWait(t *testing.T) {
+	fs := filesystem{"/main.js": ``}
+
+	server := NewServer(fs, true, "/main.js")
+	server.Quiet = 50 * time.Millisecond
+	server.MaxWait = 200 * time.Millisecond
+	server.bundle.Reload()
+
+	w := &fakeWatcher{changes: make(chan string)}
+	go server.watch(w)
+	defer close(w.changes)
+
+	// changes more often than the quiet period, mixed with unrelated files
+	fs["/main.js"] = `var x;`
+	timeout := time.Now().Add(2 * time.Second)
+	for generation := uint64(0); generation == 0; {
+		if time.Now().After(timeout) {
+			t.Fatalf("changes were not reloaded")
+		}
+		w.changes <- "/main.js"
+		w.changes <- "/build.log"
+		time.Sleep(10 * time.Millisecond)
+
+		server.statemu.Lock()
+		generation = server.generation
+		server.statemu.Unlock()
 	}
+}
 
-	fs["/main.js"] = `depends("/other.js")`
-	fs["/other.js"] = ``
+func TestGlobDependencies(t *testing.T) {
+	fs := filesystem{
+		"/main.js":           `depends("widgets/*.js"); depends("widgets/**/*.css"); depends("lib/")`,
+		"/widgets/a.js":      ``,
+		"/widgets/b.js":      ``,
+		"/widgets/sub/c.js":  ``,
+		"/widgets/x.css":     ``,
+		"/widgets/sub/y.css": ``,
+		"/lib/index.js":      ``,
+	}
 
-	changes, err = bundle.Reload()
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	main := find(bundle.All(), "/main.js")
+	expected := []string{"/widgets/a.js", "/widgets/b.js", "/widgets/sub/y.css", "/widgets/x.css", "/lib/index.js"}
+	if !sameDeps(main.Deps, expected) {
+		t.Errorf("got %v expected %v", main.Deps, expected)
+	}
+
+	fs["/widgets/new.js"] = ``
+	changes, err := bundle.Reload()
 	if err != nil {
-		t.Errorf("err %v", err)
+		t.Fatal(err)
 	}
 	sort.Sort(changesByPath(changes))
+	if len(changes) != 2 ||
+		changes[0].Next.Path != "/main.js" || !changes[0].Deps ||
+		changes[1].Prev != nil || changes[1].Next.Path != "/widgets/new.js" {
+		t.Errorf("invalid changes %v", changes)
+	}
+}
 
-	if len(changes) != 2 {
-		t.Errorf("invalid number of changes after deps change: %#v", changes)
-		return
+func TestPackageDependencies(t *testing.T) {
+	fs := filesystem{
+		"/main.js":        `depends("ui.wanderer"); depends("ui");`,
+		"/ui/ui.js":       `package("ui", function(ui){});`,
+		"/ui/wanderer.js": `depends("ui"); package("ui.wanderer", function(wanderer){});`,
 	}
 
-	if !(changes[0].Deps == true) {
-		t.Errorf("should've detected file modification %v", changes)
+	bundle := NewBundle(fs, "/main.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFiles(bundle.All(), []string{"/ui/ui.js", "/ui/wanderer.js", "/main.js"}) {
+		t.Errorf("got %v", names(bundle.All()))
+	}
+	if provides := find(bundle.All(), "/ui/wanderer.js").Provides; !sameDeps(provides, []string{"ui.wanderer"}) {
+		t.Errorf("got provides %v", provides)
 	}
 
-	if !(changes[1].Prev == nil && changes[1].Next != nil &&
-		changes[1].Next.Path == `/other.js`) {
-		t.Errorf("should've loaded new file")
+	fs["/main.js"] = `depends("ui.wanderer");` + "\n" + `depends("ui.missing");`
+	fs["/ui/copy.js"] = `package("ui.wanderer", function(wanderer){});`
+	_, err := bundle.Reload()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, expected := range []string{
+		`/main.js:1:1: package "ui.wanderer" is provided by multiple files: /ui/copy.js, /ui/wanderer.js`,
+		`/main.js:2:1: package "ui.missing" is not provided by any file`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+	if find(bundle.All(), "/main.js") == nil {
+		t.Errorf("source with unresolved packages should be kept")
 	}
+}
 
-	delete(fs, "/other.js")
+func TestPackageProviders(t *testing.T) {
+	fs := &syncFilesystem{fs: filesystem{
+		"/main.js":             `depends("ui"); depends("ui.later")`,
+		"/ui.js":               `package("ui", function(ui){});`,
+		"/other.js":            ``,
+		"/~pkg.js":             `package("ui", function(ui){});`,
+		"/dist/~pkg.js":        `package("ui", function(ui){});`,
+		"/dist/ui/copy.js":     `package("ui", function(ui){});`,
+		"/node_modules/x/x.js": `package("ui", function(ui){});`,
+	}, static: true}
 
-	changes, _ = bundle.Reload()
+	bundle := NewBundle(fs, "/main.js")
+	_, err := bundle.Reload()
 
-	if len(changes) != 1 {
-		t.Errorf("invalid number of changes after file delete: %#v", changes)
-		return
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Name != "ui.later" || len(providerErr.Providers) != 0 {
+		t.Fatalf("expected only ui.later to be unresolved, got %v", err)
 	}
-	if !(changes[0].Next == nil) {
-		t.Errorf("should've detected file deletion %#v", changes[0])
+	if deps := find(bundle.All(), "/main.js").Deps; !sameDeps(deps, []string{"/ui.js"}) {
+		t.Errorf("got deps %v", deps)
+	}
+	if isPackageName("jquery.min") || isPackageName("app.bundle") {
+		t.Errorf("file names with suffixes shouldn't be package names")
 	}
 
-	fs["/other.js"] = `blah`
-	changes, _ = bundle.Reload()
-	if len(changes) != 1 {
-		t.Errorf("invalid number of changes after file re-add: %#v", changes)
-		return
+	// polling doesn't rescan Root for unresolved packages on every reload
+	opens := fs.opens("/other.js")
+	bundle.Reload()
+	if fs.opens("/other.js") != opens {
+		t.Errorf("Root was scanned again for providers")
 	}
-	if !(changes[0].Next != nil) {
-		t.Errorf("should've detected file adding %#v", changes[0])
+
+	fs.set("/later.js", `package("ui.later", function(later){});`)
+	if _, err := bundle.ReloadFiles("/later.js"); err != nil {
+		t.Fatalf("err %v", err)
+	}
+	if deps := find(bundle.All(), "/main.js").Deps; !sameDeps(deps, []string{"/ui.js", "/later.js"}) {
+		t.Errorf("got deps %v", deps)
+	}
+}
+
+func TestLint(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("ui.wanderer"); depends("/util.js");
+ui.wanderer.renderTo(context);
+ui.grid.draw({ ui: 1 });`,
+		"/ui/wanderer.js": `package("ui.wanderer", function(wanderer){});`,
+		"/ui/grid.js":     `package("ui.grid", function(grid){ ui.grid.x = 1; });`,
+		"/util.js":        `package("util", function(util){});`,
+	}
+
+	bundle := NewBundle(fs, "/main.js", "/ui/grid.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := bundle.Lint()
+	expected := []string{
+		`/main.js:1:25: depends on /util.js, but doesn't use util`,
+		`/main.js:3:1: uses ui.grid without depending on /ui/grid.js`,
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("got %v", issues)
+	}
+	for i, issue := range issues {
+		if issue.String() != expected[i] {
+			t.Errorf("got %q expected %q", issue, expected[i])
+		}
+	}
+	if issues[0].Kind != UnusedDependency || issues[1].Kind != MissingDependency {
+		t.Errorf("invalid kinds %v", issues)
+	}
+	if again := bundle.Lint(); &again[0] != &issues[0] {
+		t.Errorf("lint issues should be cached")
+	}
+
+	fs["/main.js"] += `
+util.x = 1;`
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if issues := bundle.Lint(); len(issues) != 1 {
+		t.Errorf("lint issues should be updated after reload, got %v", issues)
+	}
+}
+
+func TestLintOwnNamespace(t *testing.T) {
+	fs := filesystem{
+		"/ui.js":    `package("ui", function(ui){});`,
+		"/other.js": `depends("ui.js"); package("ui.other", function(other){ ui.other.go(); });`,
+		"/self.js":  `package("ui.self", function(self){ ui.self.go(); ui.x(); });`,
+	}
+
+	bundle := NewBundle(fs, "/other.js", "/self.js")
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := bundle.Lint()
+	expected := []string{
+		`/other.js:1:1: depends on ui.js, but doesn't use ui`,
+		`/self.js:1:50: uses ui without depending on /ui.js`,
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("got %v", issues)
+	}
+	for i, issue := range issues {
+		if issue.String() != expected[i] {
+			t.Errorf("got %q expected %q", issue, expected[i])
+		}
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	fs := filesystem{
+		"/main.js": `depends("lib.js");
+// comment
+function greet(longName) {
+	var message = "hello " + longName; /* block */
+	return message;
+}
+greet("x");`,
+		"/lib.js": `var global = 1;`,
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	bundle.Minify = true
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := bundle.Merged(".js")
+	code := string(merged.Content)
+	for _, unexpected := range []string{"comment", "block", "longName", "message", "\t"} {
+		if strings.Contains(code, unexpected) {
+			t.Errorf("unexpected %q in:\n%s", unexpected, code)
+		}
+	}
+	for _, expected := range []string{"function greet(", `greet("x")`, "var global=1"} {
+		if !strings.Contains(code, expected) {
+			t.Errorf("expected %q in:\n%s", expected, code)
+		}
+	}
+	if len(merged.Map.Names) == 0 {
+		t.Errorf("expected renamed identifiers in source map")
+	}
+
+	if bundle.Merged(".js") != merged {
+		t.Errorf("minified bundle should be cached")
+	}
+	fs["/lib.js"] = `var global = 2;`
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Merged(".js") == merged {
+		t.Errorf("cache should be invalidated after changes")
+	}
+}
+
+func TestMinifyJSBlockScopes(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{`var g=1;function f(){{let g=2}return g}`, `var g=1;function f(){{let g=2}return g}`},
+		{`var g=1;function f(c){if(c){const g=2}return g}`, `var g=1;function f(a){if(a){const g=2}return g}`},
+		{`var e=1;function f(){try{}catch(e){}return e}`, `var e=1;function f(){try{}catch(e){}return e}`},
+		{`function o(){var g=1;function f(){{let g=2}return g}return f}`, `function o(){var g=1;function a(){{let g=2}return g}return a}`},
+		// let and const in the function body behave like var
+		{`function f(){var x=1;let y=2;const z=3;return x+y+z}`, `function f(){var a=1;let b=2;const c=3;return a+b+c}`},
+	}
+	for _, test := range tests {
+		if out, _ := minifyJS([]byte(test.in)); string(out) != test.out {
+			t.Errorf("%s: got %s, expected %s", test.in, out, test.out)
+		}
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	fs := filesystem{
+		"/main.css": `@depends "base.css";
+/* comment */
+.a { color: #FFFFFF; margin: 0px 0.50em; }
+.a { padding: 0 }
+.b { padding: 0 }
+.c::-webkit-scrollbar { padding: 0 }
+li:nth-child(2n + 1) > a { width: calc(100% - 0px) }
+.d { color: red }
+.e:has(.f) { color: red }
+.g::before { color: red }
+.h:hover { color: red }
+.i { color: red }`,
+		"/base.css": `body { margin: 0 }`,
+	}
+
+	bundle := NewBundle(fs, "/main.css")
+	bundle.Minify = true
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := bundle.Merged(".css")
+	expected := `body{margin:0}` +
+		`.a{color:#fff;margin:0 .5em;padding:0}` +
+		`.b{padding:0}` +
+		`.c::-webkit-scrollbar{padding:0}` +
+		`li:nth-child(2n + 1)>a{width:calc(100% - 0px)}` +
+		`.d{color:red}` +
+		`.e:has(.f){color:red}` +
+		`.g::before{color:red}` +
+		`.h:hover,.i{color:red}` + "\n" +
+		`/*# sourceMappingURL=~pkg.css.map */` + "\n"
+	if got := string(merged.Content); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	mappings, err := merged.Map.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := newMappingIndex(mappings)
+	col := strings.Index(string(merged.Content), ".b{")
+	mapping, ok := index.lookup(0, col)
+	if !ok {
+		t.Fatalf(".b is not mapped")
+	}
+	if merged.Map.Sources[mapping.Source] != "main.css" || mapping.Line != 4 || mapping.Col != 0 {
+		t.Errorf(".b mapped to %s:%d:%d", merged.Map.Sources[mapping.Source], mapping.Line, mapping.Col)
 	}
 }
 
@@ -168,6 +1485,15 @@ func names(sources []*Source) []string {
 	return r
 }
 
+func find(sources []*Source, path string) *Source {
+	for _, src := range sources {
+		if src.Path == path {
+			return src
+		}
+	}
+	return nil
+}
+
 func sameFiles(sources []*Source, expected []string) bool {
 	if len(sources) != len(expected) {
 		return false
@@ -184,14 +1510,99 @@ type filesystem map[string]string
 
 var pseudoTime, _ = time.Parse(time.RFC1123, time.RFC1123)
 
+// staticTime is the modification time of files in a static syncFilesystem
+var staticTime = pseudoTime
+
 func (fs filesystem) Open(name string) (http.File, error) {
 	pseudoTime = pseudoTime.Add(time.Second)
 	if data, ok := fs[name]; ok {
 		return &file{name, pseudoTime, bytes.NewReader([]byte(data))}, nil
 	}
+	if infos := fs.readdir(name); len(infos) > 0 {
+		return &dir{file{name, pseudoTime, bytes.NewReader(nil)}, infos}, nil
+	}
 	return nil, os.ErrNotExist
 }
 
+// readdir lists files and directories directly inside name
+func (fs filesystem) readdir(name string) []os.FileInfo {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	seen := make(map[string]bool)
+	infos := []os.FileInfo{}
+	for path := range fs {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		child := strings.SplitN(path[len(prefix):], "/", 2)
+		if seen[child[0]] {
+			continue
+		}
+		seen[child[0]] = true
+		if len(child) > 1 {
+			infos = append(infos, &dir{file: file{prefix + child[0], pseudoTime, bytes.NewReader(nil)}})
+		} else {
+			infos = append(infos, &file{prefix + child[0], pseudoTime, bytes.NewReader([]byte(fs[path]))})
+		}
+	}
+	return infos
+}
+
+// syncFilesystem is a filesystem that can be modified during reloads
+type syncFilesystem struct {
+	mu     sync.Mutex
+	fs     filesystem
+	counts map[string]int
+	block  func(name string)
+	static bool // files keep their modification time
+}
+
+func (fs *syncFilesystem) Open(name string) (http.File, error) {
+	if fs.block != nil {
+		fs.block(name)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.counts == nil {
+		fs.counts = make(map[string]int)
+	}
+	fs.counts[name]++
+	opened, err := fs.fs.Open(name)
+	if fs.static {
+		switch f := opened.(type) {
+		case *file:
+			f.time = staticTime
+		case *dir:
+			f.time = staticTime
+		}
+	}
+	return opened, err
+}
+
+func (fs *syncFilesystem) set(name, data string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.fs[name] = data
+}
+
+func (fs *syncFilesystem) opens(name string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.counts[name]
+}
+
+// foldFilesystem opens files case-insensitively like the default
+// file systems on macOS and Windows
+type foldFilesystem struct{ filesystem }
+
+func (fs foldFilesystem) Open(name string) (http.File, error) {
+	for path := range fs.filesystem {
+		if strings.EqualFold(path, name) {
+			return fs.filesystem.Open(path)
+		}
+	}
+	return fs.filesystem.Open(name)
+}
+
 type file struct {
 	name string
 	time time.Time
@@ -210,3 +1621,19 @@ func (f *file) Mode() os.FileMode  { return os.ModePerm }
 func (f *file) ModTime() time.Time { return f.time }
 func (f *file) IsDir() bool        { return false }
 func (f *file) Sys() interface{}   { return nil }
+
+type dir struct {
+	file
+	infos []os.FileInfo
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) { return d.infos, nil }
+func (d *dir) Stat() (os.FileInfo, error)               { return d, nil }
+func (d *dir) IsDir() bool                              { return true }
+
+// fakeWatcher reports changes sent to its channel
+type fakeWatcher struct{ changes chan string }
+
+func (w *fakeWatcher) Add(dir string) error   { return nil }
+func (w *fakeWatcher) Changes() <-chan string { return w.changes }
+func (w *fakeWatcher) Close() error           { return nil }
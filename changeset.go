@@ -0,0 +1,77 @@
+package livepkg
+
+import "sort"
+
+// ChangeSet is a group of changes that are applied together,
+// the changes lead from snapshot generation Since to Generation
+type ChangeSet struct {
+	Since      uint64    `json:"since"`
+	Generation uint64    `json:"generation"`
+	Changes    []*Change `json:"changes"`
+}
+
+// mergeChanges merges later changes of the same files into changes,
+// files that were added and then removed are dropped
+func mergeChanges(changes, later []*Change) []*Change {
+	index := make(map[string]int, len(changes))
+	for i, change := range changes {
+		index[change.path()] = i
+	}
+
+	merged := append([]*Change{}, changes...)
+	for _, change := range later {
+		i, ok := index[change.path()]
+		if !ok {
+			index[change.path()] = len(merged)
+			merged = append(merged, change)
+			continue
+		}
+		merged[i] = &Change{
+			Prev: merged[i].Prev,
+			Next: change.Next,
+			Deps: merged[i].Deps || change.Deps,
+		}
+	}
+
+	result := merged[:0]
+	for _, change := range merged {
+		if change.Prev != nil || change.Next != nil {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// sortChanges sorts changes in the order of sorted sources,
+// removed files come first
+func sortChanges(changes []*Change, sorted []*Source) {
+	order := make(map[string]int, len(sorted))
+	for i, src := range sorted {
+		order[src.Path] = i
+	}
+	position := func(change *Change) int {
+		if change.Next == nil {
+			return -1
+		}
+		if i, ok := order[change.Next.Path]; ok {
+			return i
+		}
+		return len(sorted)
+	}
+
+	sort.SliceStable(changes, func(i, k int) bool {
+		a, b := position(changes[i]), position(changes[k])
+		if a != b {
+			return a < b
+		}
+		return changes[i].path() < changes[k].path()
+	})
+}
+
+// path returns the path of the changed file
+func (change *Change) path() string {
+	if change.Next != nil {
+		return change.Next.Path
+	}
+	return change.Prev.Path
+}
@@ -0,0 +1,53 @@
+package livepkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// wrapCommonJS wraps processed CommonJS source into a package.define call,
+// which gives it require, module and exports. The wrapper doesn't add lines
+// before the code, so line numbers stay the same.
+func wrapCommonJS(src *Source) []byte {
+	deps := make(map[string]string, len(src.Imports))
+	for _, imp := range src.Imports {
+		deps[imp.Spec] = imp.Path
+	}
+	depsJSON, _ := json.Marshal(deps)
+
+	var buf bytes.Buffer
+	buf.WriteString("package.define(" + strconv.Quote(src.Path) + ", ")
+	buf.Write(depsJSON)
+	buf.WriteString(", function(require, module, exports){")
+	buf.Write(src.Processed)
+	buf.WriteString("\n});")
+	return buf.Bytes()
+}
+
+// wrapRequired wraps UMD sources that are required by CommonJS sources, so
+// that require can find them. Which sources are required is only known after
+// loading all of them, UMD sources that are not required anymore are
+// processed again without the wrapper.
+func (b *Bundle) wrapRequired(track map[string]*Change) {
+	required := make(map[string]bool)
+	for _, info := range track {
+		if src := info.Next; src != nil && src.CommonJS && !src.UMD {
+			for _, dep := range src.Deps {
+				required[dep] = true
+			}
+		}
+	}
+
+	for path, info := range track {
+		src := info.Next
+		if src == nil || !src.UMD || src.CommonJS == required[path] {
+			continue
+		}
+		wrapped := *src
+		wrapped.CommonJS = required[path]
+		if err := b.process(&wrapped); err == nil {
+			info.Next = &wrapped
+		}
+	}
+}
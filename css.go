@@ -0,0 +1,403 @@
+package livepkg
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// cssKind is the kind of a CSS token
+type cssKind int
+
+const (
+	cssEOF       cssKind = iota
+	cssSpace             // whitespace
+	cssComment           // comment
+	cssString            // quoted string
+	cssURL               // url(...), Value contains the unquoted url
+	cssAtKeyword         // @name
+	cssHash              // #name
+	cssIdent             // identifier
+	cssFunction          // name(
+	cssNumber            // number with optional unit or percentage
+	cssPunct             // any other character
+)
+
+// cssToken is a single CSS token
+type cssToken struct {
+	Kind  cssKind
+	Text  string // raw text of the token
+	Value string // unquoted value for strings and urls
+
+	Offset int // byte offset in source
+	Line   int // 1-based line
+	Col    int // 1-based byte column
+}
+
+// cssTokenize splits CSS source into tokens
+func cssTokenize(src []byte) []cssToken {
+	tokens := []cssToken{}
+
+	line, col := 1, 1
+	for pos := 0; pos < len(src); {
+		tok := cssToken{Offset: pos, Line: line, Col: col}
+		end := pos + 1
+
+		c := src[pos]
+		switch {
+		case isCSSSpace(c):
+			tok.Kind = cssSpace
+			for end < len(src) && isCSSSpace(src[end]) {
+				end++
+			}
+		case c == '/' && at(src, pos+1) == '*':
+			tok.Kind = cssComment
+			if i := bytes.Index(src[pos+2:], []byte("*/")); i >= 0 {
+				end = pos + 2 + i + 2
+			} else {
+				end = len(src)
+			}
+		case c == '"' || c == '\'':
+			tok.Kind = cssString
+			end = cssStringEnd(src, pos)
+			tok.Value = cssUnquote(string(src[pos:end]))
+		case c == '@' && isCSSNameStart(src, pos+1):
+			tok.Kind = cssAtKeyword
+			end = cssNameEnd(src, pos+1)
+		case c == '#' && end < len(src) && isCSSName(src[end]):
+			tok.Kind = cssHash
+			end = cssNameEnd(src, pos+1)
+		case isDigit(c) ||
+			(c == '.' && isDigit(at(src, pos+1))) ||
+			((c == '+' || c == '-') && (isDigit(at(src, pos+1)) || at(src, pos+1) == '.' && isDigit(at(src, pos+2)))):
+			tok.Kind = cssNumber
+			for end < len(src) && (isDigit(src[end]) || src[end] == '.' && isDigit(at(src, end+1))) {
+				end++
+			}
+			if end < len(src) && src[end] == '%' {
+				end++
+			} else if isCSSNameStart(src, end) {
+				end = cssNameEnd(src, end)
+			}
+		case isCSSNameStart(src, pos):
+			tok.Kind = cssIdent
+			end = cssNameEnd(src, pos)
+			if at(src, end) != '(' {
+				break
+			}
+			end++
+			tok.Kind = cssFunction
+			if strings.EqualFold(string(src[pos:end]), "url(") {
+				if urlEnd, value, ok := cssURLEnd(src, end); ok {
+					tok.Kind = cssURL
+					tok.Value = value
+					end = urlEnd
+				}
+			}
+		default:
+			tok.Kind = cssPunct
+		}
+
+		tok.Text = string(src[pos:end])
+		for _, c := range src[pos:end] {
+			if c == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+
+		tokens = append(tokens, tok)
+		pos = end
+	}
+
+	return tokens
+}
+
+// at returns src[i] or 0 when out of bounds
+func at(src []byte, i int) byte {
+	if i < len(src) {
+		return src[i]
+	}
+	return 0
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isCSSName(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '-' || c == '_' || c == '\\' || c >= 0x80
+}
+
+// isCSSNameStart returns whether an identifier starts at src[pos]
+func isCSSNameStart(src []byte, pos int) bool {
+	c := at(src, pos)
+	if c == '-' {
+		c = at(src, pos+1)
+		return c == '-' || isLetter(c) || c == '_' || c == '\\' || c >= 0x80
+	}
+	return isLetter(c) || c == '_' || c == '\\' || c >= 0x80
+}
+
+// cssNameEnd returns the end of the name starting at pos
+func cssNameEnd(src []byte, pos int) int {
+	for pos < len(src) && isCSSName(src[pos]) {
+		if src[pos] == '\\' {
+			pos++
+		}
+		pos++
+	}
+	if pos > len(src) {
+		pos = len(src)
+	}
+	return pos
+}
+
+// cssStringEnd returns the end of the string starting at pos
+func cssStringEnd(src []byte, pos int) int {
+	q := src[pos]
+	for end := pos + 1; end < len(src); end++ {
+		switch src[end] {
+		case '\\':
+			end++
+		case q:
+			return end + 1
+		case '\n':
+			// unterminated string
+			return end
+		}
+	}
+	return len(src)
+}
+
+// cssURLEnd returns end and value of url(...) content starting at pos
+func cssURLEnd(src []byte, pos int) (end int, value string, ok bool) {
+	start := pos
+	for pos < len(src) && isCSSSpace(src[pos]) {
+		pos++
+	}
+	if c := at(src, pos); c == '"' || c == '\'' {
+		strEnd := cssStringEnd(src, pos)
+		value = cssUnquote(string(src[pos:strEnd]))
+		pos = strEnd
+		for pos < len(src) && isCSSSpace(src[pos]) {
+			pos++
+		}
+		if at(src, pos) != ')' {
+			return start, "", false
+		}
+		return pos + 1, value, true
+	}
+
+	i := bytes.IndexByte(src[pos:], ')')
+	if i < 0 {
+		return start, "", false
+	}
+	value = strings.TrimSpace(string(src[pos : pos+i]))
+	return pos + i + 1, value, true
+}
+
+// cssUnquote removes quotes and escapes from a CSS string
+func cssUnquote(s string) string {
+	if len(s) < 2 {
+		return ""
+	}
+	if s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	} else {
+		s = s[1:]
+	}
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// cssQuote returns s as a CSS string, quotes, backslashes and control
+// characters are written as hexadecimal escapes followed by a space
+func cssQuote(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' || r < 0x20 || r == 0x7f {
+			buf.WriteString(`\` + strconv.FormatInt(int64(r), 16) + " ")
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// cssSignificant returns the index of next token after i that is not
+// whitespace or comment, or len(tokens) if there is none
+func cssSignificant(tokens []cssToken, i int) int {
+	for i++; i < len(tokens); i++ {
+		if tokens[i].Kind != cssSpace && tokens[i].Kind != cssComment {
+			return i
+		}
+	}
+	return len(tokens)
+}
+
+// cssIsImportArg returns whether tokens[i] is the argument of @import
+func cssIsImportArg(tokens []cssToken, i int) bool {
+	for i--; i >= 0; i-- {
+		if tokens[i].Kind != cssSpace && tokens[i].Kind != cssComment {
+			return strings.EqualFold(tokens[i].Text, "@import")
+		}
+	}
+	return false
+}
+
+// cssImports finds @depends and @import rules in CSS source
+// and url(...) references to assets such as images and fonts,
+// references starting with a prefix in aliases are always local
+func cssImports(data []byte, aliases map[string]string) (imports, assets []Import) {
+	imports, assets = []Import{}, []Import{}
+
+	tokens := cssTokenize(data)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Kind {
+		case cssAtKeyword:
+			name := strings.ToLower(tok.Text)
+			if name != "@depends" && name != "@import" {
+				continue
+			}
+			next := cssSignificant(tokens, i)
+			if next >= len(tokens) {
+				continue
+			}
+			arg := tokens[next]
+			if arg.Kind != cssString && !(arg.Kind == cssURL && name == "@import") {
+				continue
+			}
+			i = next
+			if !isLocal(arg.Value) && !isAliased(aliases, arg.Value) {
+				continue
+			}
+			imports = append(imports, Import{
+				Spec: trimQuery(arg.Value),
+				Line: tok.Line,
+				Col:  tok.Col,
+			})
+		case cssURL:
+			if tok.Value == "" || !isLocal(tok.Value) && !isAliased(aliases, tok.Value) {
+				continue
+			}
+			assets = append(assets, Import{
+				Spec: trimQuery(tok.Value),
+				Line: tok.Line,
+				Col:  tok.Col,
+			})
+		}
+	}
+	return imports, assets
+}
+
+// cssRewriteURLs replaces references in url(...) and @import with
+// the result of rewrite
+func cssRewriteURLs(data []byte, rewrite func(ref string) string) []byte {
+	var buf bytes.Buffer
+
+	tokens := cssTokenize(data)
+	for i, tok := range tokens {
+		switch tok.Kind {
+		case cssURL:
+			if ref := rewrite(tok.Value); ref != tok.Value {
+				buf.WriteString("url(" + cssQuote(ref) + ")")
+				continue
+			}
+		case cssString:
+			if cssIsImportArg(tokens, i) {
+				if ref := rewrite(tok.Value); ref != tok.Value {
+					buf.WriteString(cssQuote(ref))
+					continue
+				}
+			}
+		}
+		buf.WriteString(tok.Text)
+	}
+
+	return buf.Bytes()
+}
+
+// cssMerge prepares stylesheet at path from for merging into a bundle located
+// at the root. Aliases are replaced, relative references are rebased against
+// from and @import rules for files that are already in the bundle are removed.
+func cssMerge(data []byte, from string, aliases map[string]string, bundled func(path string) bool) []byte {
+	var buf bytes.Buffer
+
+	tokens := cssTokenize(data)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Kind {
+		case cssAtKeyword:
+			if !strings.EqualFold(tok.Text, "@import") {
+				break
+			}
+			next := cssSignificant(tokens, i)
+			if next >= len(tokens) {
+				break
+			}
+			arg := tokens[next]
+			if arg.Kind != cssString && arg.Kind != cssURL {
+				break
+			}
+			ref := aliasSpec(aliases, arg.Value)
+			if !isLocal(ref) || !bundled(resolvePath(from, trimQuery(ref))) {
+				break
+			}
+
+			// skip the whole rule, but keep the line structure
+			for ; i < len(tokens) && tokens[i].Text != ";"; i++ {
+				buf.WriteString(strings.Repeat("\n", strings.Count(tokens[i].Text, "\n")))
+			}
+			continue
+		case cssURL:
+			if ref := rebaseRef(from, aliasSpec(aliases, tok.Value)); ref != tok.Value {
+				buf.WriteString("url(" + cssQuote(ref) + ")")
+				continue
+			}
+		case cssString:
+			if cssIsImportArg(tokens, i) {
+				if ref := rebaseRef(from, aliasSpec(aliases, tok.Value)); ref != tok.Value {
+					buf.WriteString(cssQuote(ref))
+					continue
+				}
+			}
+		}
+		buf.WriteString(tok.Text)
+	}
+
+	return buf.Bytes()
+}
+
+// rebaseRef rebases a relative reference in file from,
+// so that it is relative to the root instead
+func rebaseRef(from, ref string) string {
+	if ref == "" || !isLocal(ref) || strings.HasPrefix(ref, "/") {
+		return ref
+	}
+
+	suffix := ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref, suffix = ref[:i], ref[i:]
+	}
+	if ref == "" {
+		return suffix
+	}
+
+	return strings.TrimPrefix(resolvePath(from, ref), "/") + suffix
+}
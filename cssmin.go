@@ -0,0 +1,388 @@
+package livepkg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// minifyCSS removes comments and whitespace from CSS, shortens colors and
+// numbers and merges adjacent rules with the same selector or the same
+// declarations. It returns the minified code and a source map from minified
+// code to data.
+func minifyCSS(data []byte) ([]byte, *SourceMap) {
+	rules, _ := cssParseRules(cssTokenize(data), 0)
+	rules = cssMergeRules(rules)
+
+	w := &mapWriter{}
+	for _, rule := range rules {
+		rule.write(w)
+	}
+	return w.buf.Bytes(), w.Map()
+}
+
+// cssPiece is a part of minified output
+type cssPiece struct {
+	Text string
+	Line int // 1-based line in source, 0 when the piece was added
+	Col  int // 1-based byte column in source
+}
+
+// cssRule is a rule or an at-rule in a stylesheet
+type cssRule struct {
+	prelude []cssPiece // selector or at-rule name with parameters
+	block   []cssPiece // declarations
+
+	statement bool       // rule ends with ";" instead of a block
+	nested    bool       // block contains rules instead of declarations
+	opaque    bool       // block contains nested blocks that are kept as is
+	rules     []*cssRule // rules inside nested block
+}
+
+// cssNestedAtRules contains at-rules that contain other rules
+var cssNestedAtRules = map[string]bool{
+	"@media":          true,
+	"@supports":       true,
+	"@document":       true,
+	"@-moz-document":  true,
+	"@container":      true,
+	"@layer":          true,
+	"@scope":          true,
+	"@starting-style": true,
+}
+
+// cssParseRules parses rules starting at i until the end of the enclosing
+// block, it returns the rules and index after the closing brace
+func cssParseRules(tokens []cssToken, i int) ([]*cssRule, int) {
+	rules := []*cssRule{}
+	for {
+		for i < len(tokens) && (tokens[i].Kind == cssSpace || tokens[i].Kind == cssComment || tokens[i].Text == ";") {
+			i++
+		}
+		if i >= len(tokens) {
+			return rules, i
+		}
+		if tokens[i].Text == "}" {
+			return rules, i + 1
+		}
+
+		start, depth := i, 0
+		for ; i < len(tokens); i++ {
+			tok := tokens[i]
+			if tok.Kind == cssFunction || tok.Text == "(" || tok.Text == "[" {
+				depth++
+			} else if (tok.Text == ")" || tok.Text == "]") && depth > 0 {
+				depth--
+			} else if depth == 0 && (tok.Text == "{" || tok.Text == ";" || tok.Text == "}") {
+				break
+			}
+		}
+
+		rule := &cssRule{prelude: cssMinifyTokens(tokens[start:i], false)}
+		rules = append(rules, rule)
+		if i >= len(tokens) || tokens[i].Text != "{" {
+			rule.statement = true
+			if i < len(tokens) && tokens[i].Text == ";" {
+				i++
+			}
+			continue
+		}
+
+		name := ""
+		if tokens[start].Kind == cssAtKeyword {
+			name = strings.ToLower(tokens[start].Text)
+		}
+		if cssNestedAtRules[name] || strings.HasSuffix(name, "keyframes") {
+			rule.nested = true
+			rule.rules, i = cssParseRules(tokens, i+1)
+			continue
+		}
+
+		open, braces := i+1, 1
+		for i++; i < len(tokens); i++ {
+			if tokens[i].Text == "{" {
+				braces++
+				rule.opaque = true
+			} else if tokens[i].Text == "}" {
+				braces--
+				if braces == 0 {
+					break
+				}
+			}
+		}
+		if i > len(tokens) {
+			i = len(tokens)
+		}
+		rule.block = cssMinifyTokens(tokens[open:i], !rule.opaque)
+		i++
+	}
+}
+
+// cssMinifyTokens removes unnecessary whitespace from a selector, an at-rule
+// prelude or declarations. In declarations colors and numbers are shortened.
+func cssMinifyTokens(tokens []cssToken, declarations bool) []cssPiece {
+	pieces := []cssPiece{}
+
+	var prev cssToken
+	space := false
+	depth := 0
+	property, value := "", false
+	for _, tok := range tokens {
+		if tok.Kind == cssSpace || tok.Kind == cssComment {
+			space = true
+			continue
+		}
+
+		text := tok.Text
+		if declarations && depth == 0 {
+			switch {
+			case text == ";":
+				property, value = "", false
+				if len(pieces) == 0 || pieces[len(pieces)-1].Text == ";" {
+					space = false
+					continue
+				}
+			case text == ":" && !value:
+				value = true
+			case tok.Kind == cssIdent && property == "":
+				property = strings.ToLower(text)
+			}
+		}
+		if declarations && value && !strings.HasPrefix(property, "--") && property != "unicode-range" {
+			switch tok.Kind {
+			case cssHash:
+				text = cssShortColor(text)
+			case cssNumber:
+				text = cssShortNumber(text, depth == 0 && !strings.HasPrefix(property, "flex"))
+			}
+		}
+
+		if tok.Kind == cssURL {
+			text = "url(" + cssURLArg(tok.Value) + ")"
+		}
+
+		if space && len(pieces) > 0 && cssNeedsSpace(prev, tok, declarations, depth) {
+			pieces = append(pieces, cssPiece{Text: " "})
+		}
+		pieces = append(pieces, cssPiece{Text: text, Line: tok.Line, Col: tok.Col})
+
+		if tok.Kind == cssFunction || text == "(" {
+			depth++
+		} else if text == ")" && depth > 0 {
+			depth--
+		}
+		prev, space = tok, false
+	}
+
+	for len(pieces) > 0 && pieces[len(pieces)-1].Text == ";" {
+		pieces = pieces[:len(pieces)-1]
+	}
+	return pieces
+}
+
+// cssNeedsSpace returns whether whitespace between prev and next is significant
+func cssNeedsSpace(prev, next cssToken, declarations bool, depth int) bool {
+	if prev.Kind == cssFunction {
+		return false
+	}
+	switch prev.Text {
+	case "{", "}", ";", ",", ">", "~", "(", "[", "=", "!", "/", ":":
+		return false
+	}
+	switch next.Text {
+	case "{", "}", ";", ",", ">", "~", ")", "]", "=", "!", "/":
+		return false
+	case ":":
+		return !declarations
+	}
+	// adjacent sibling combinator in selectors, but not "+" in calc()
+	if (prev.Text == "+" || next.Text == "+") && depth == 0 && !declarations {
+		return false
+	}
+	return true
+}
+
+// cssURLArg returns ref quoted only when necessary
+func cssURLArg(ref string) string {
+	if ref == "" || strings.ContainsAny(ref, " \t\n\r\f\"'()\\") {
+		return cssQuote(ref)
+	}
+	return ref
+}
+
+// cssShortColor lowercases hex color and uses the 3 or 4 digit form when possible
+func cssShortColor(text string) string {
+	hex := strings.ToLower(text[1:])
+	for i := 0; i < len(hex); i++ {
+		if !isDigit(hex[i]) && (hex[i] < 'a' || hex[i] > 'f') {
+			return text
+		}
+	}
+	switch len(hex) {
+	case 3, 4:
+	case 6, 8:
+		short := ""
+		for i := 0; i < len(hex); i += 2 {
+			if hex[i] != hex[i+1] {
+				return "#" + hex
+			}
+			short += hex[i : i+1]
+		}
+		hex = short
+	default:
+		return text
+	}
+	return "#" + hex
+}
+
+// cssLengthUnits contains units that can be omitted for zero lengths
+var cssLengthUnits = map[string]bool{
+	"px": true, "em": true, "rem": true, "ex": true, "ch": true,
+	"vw": true, "vh": true, "vmin": true, "vmax": true,
+	"cm": true, "mm": true, "q": true, "in": true, "pt": true, "pc": true,
+}
+
+// cssShortNumber removes unnecessary zeros from a number and
+// the unit from zero length when dropUnit is set
+func cssShortNumber(text string, dropUnit bool) string {
+	end := 0
+	for end < len(text) && (isDigit(text[end]) || strings.IndexByte("+-.", text[end]) >= 0) {
+		end++
+	}
+	num, unit := text[:end], text[end:]
+
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return text
+	}
+	if value == 0 && (unit == "" || dropUnit && cssLengthUnits[strings.ToLower(unit)]) {
+		return "0"
+	}
+
+	if strings.Contains(num, ".") {
+		num = strings.TrimRight(num, "0")
+		num = strings.TrimSuffix(num, ".")
+	}
+	sign := ""
+	if num != "" && (num[0] == '-' || num[0] == '+') {
+		sign, num = num[:1], num[1:]
+	}
+	if strings.HasPrefix(num, "0.") {
+		num = num[1:]
+	}
+	return sign + num + unit
+}
+
+// cssMergeRules merges adjacent rules with the same selector and adjacent
+// rules with the same declarations, empty rules and @depends are removed
+func cssMergeRules(rules []*cssRule) []*cssRule {
+	merged := []*cssRule{}
+	for _, rule := range rules {
+		if rule.statement && rule.isAtRule() && strings.EqualFold(rule.prelude[0].Text, "@depends") {
+			continue
+		}
+		if rule.nested {
+			rule.rules = cssMergeRules(rule.rules)
+			if len(rule.rules) == 0 {
+				continue
+			}
+		} else if !rule.statement && len(rule.block) == 0 && !rule.isAtRule() {
+			continue
+		}
+
+		if len(merged) == 0 {
+			merged = append(merged, rule)
+			continue
+		}
+
+		last := merged[len(merged)-1]
+		switch {
+		case last.isStyle() && rule.isStyle() && cssSameText(last.prelude, rule.prelude):
+			last.block = append(append(last.block, cssPiece{Text: ";"}), rule.block...)
+		case last.isStyle() && rule.isStyle() && cssSameText(last.block, rule.block) &&
+			!last.mayBeUnsupported() && !rule.mayBeUnsupported():
+			last.prelude = append(append(last.prelude, cssPiece{Text: ","}), rule.prelude...)
+		case last.isConditional() && rule.isConditional() && cssSameText(last.prelude, rule.prelude):
+			last.rules = cssMergeRules(append(last.rules, rule.rules...))
+		default:
+			merged = append(merged, rule)
+		}
+	}
+	return merged
+}
+
+// isAtRule returns whether rule starts with an at-keyword
+func (rule *cssRule) isAtRule() bool {
+	return len(rule.prelude) > 0 && strings.HasPrefix(rule.prelude[0].Text, "@")
+}
+
+// isStyle returns whether rule is a plain style rule with declarations
+func (rule *cssRule) isStyle() bool {
+	return !rule.statement && !rule.nested && !rule.opaque && !rule.isAtRule()
+}
+
+// isConditional returns whether rule is @media or @supports
+func (rule *cssRule) isConditional() bool {
+	if !rule.nested || !rule.isAtRule() {
+		return false
+	}
+	name := strings.ToLower(rule.prelude[0].Text)
+	return name == "@media" || name == "@supports"
+}
+
+// mayBeUnsupported returns whether selector contains pseudo-elements,
+// functional or vendor prefixed pseudo-classes, which invalidate the whole
+// selector list in browsers that don't support them
+func (rule *cssRule) mayBeUnsupported() bool {
+	for i, piece := range rule.prelude {
+		if piece.Text != ":" || i+1 >= len(rule.prelude) {
+			continue
+		}
+		next := rule.prelude[i+1].Text
+		if next == ":" || strings.HasPrefix(next, "-") || strings.HasSuffix(next, "(") {
+			return true
+		}
+	}
+	return false
+}
+
+// write writes minified rule to w
+func (rule *cssRule) write(w *mapWriter) {
+	cssWritePieces(w, rule.prelude)
+	if rule.statement {
+		w.WriteString(";")
+		return
+	}
+
+	w.WriteString("{")
+	if rule.nested {
+		for _, inner := range rule.rules {
+			inner.write(w)
+		}
+	} else {
+		cssWritePieces(w, rule.block)
+	}
+	w.WriteString("}")
+}
+
+// cssWritePieces writes pieces to w
+func cssWritePieces(w *mapWriter, pieces []cssPiece) {
+	for _, piece := range pieces {
+		if piece.Line > 0 {
+			w.mark(piece.Line-1, piece.Col-1, "")
+		}
+		w.WriteString(piece.Text)
+	}
+}
+
+// cssSameText returns whether a and b produce the same output
+func cssSameText(a, b []cssPiece) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
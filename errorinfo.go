@@ -0,0 +1,41 @@
+package livepkg
+
+// errorInfo is the JSON form of an error shown by the reloader
+type errorInfo struct {
+	Kind    string `json:"kind"` // "cycle", "missing" or "error"
+	Message string `json:"message"`
+	// Detail is *CycleError or *MissingDependencyError
+	Detail error `json:"detail,omitempty"`
+}
+
+// errorInfos flattens err into a list of errors for the reloader
+func errorInfos(err error) []errorInfo {
+	infos := []errorInfo{}
+	switch err := err.(type) {
+	case nil:
+	case Errors:
+		for _, err := range err {
+			infos = append(infos, errorInfos(err)...)
+		}
+	case *CycleError:
+		infos = append(infos, errorInfo{Kind: "cycle", Message: err.Error(), Detail: err})
+	case *MissingDependencyError:
+		infos = append(infos, errorInfo{Kind: "missing", Message: err.Error(), Detail: err})
+	default:
+		infos = append(infos, errorInfo{Kind: "error", Message: err.Error()})
+	}
+	return infos
+}
+
+// sameErrorInfos returns whether a and b contain the same messages
+func sameErrorInfos(a, b []errorInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Kind != b[i].Kind || a[i].Message != b[i].Message {
+			return false
+		}
+	}
+	return true
+}
@@ -1,14 +1,15 @@
 package livepkg
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/url"
 	"path"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -26,13 +27,63 @@ type Source struct {
 
 	Content   []byte `json:"-"` // original content on disk
 	Processed []byte `json:"-"` // pre-processed content in some cases
+
+	// Map is the source map from Content to Processed,
+	// nil when processing didn't move content around
+	Map *SourceMap `json:"-"`
+
+	Imports []Import `json:"-"` // dependencies as found in content
+
+	// Provides is the list of package names declared with package("name", ...),
+	// other files can depend on them by name
+	Provides []string `json:"provides,omitempty"`
+
+	// Module is true for JavaScript files that use import or export statements
+	Module bool `json:"module,omitempty"`
+	// CommonJS is true for JavaScript files that use require or module.exports,
+	// such files are wrapped into a module definition when processed
+	CommonJS bool `json:"commonjs,omitempty"`
+	// UMD is true for JavaScript files that check whether module, exports,
+	// define or require exist, such files are wrapped like CommonJS only
+	// when a CommonJS source requires them
+	UMD bool `json:"umd,omitempty"`
+
+	// Assets is the list of absolute paths to images, fonts and other files
+	// referenced by the source that are not loaded as separate sources
+	Assets []string `json:"assets,omitempty"`
+
+	// dynamic is true when source has glob or directory dependencies,
+	// which have to be checked on every reload
+	dynamic bool
+	// patterns contains the absolute glob patterns and directories,
+	// ending with "/", of the glob and directory dependencies
+	patterns []string
+	// unresolved is true when source has package dependencies without
+	// a single providing file, which are retried when providers may change
+	unresolved bool
+	// aliases are the Bundle.Aliases used when reading the source
+	aliases map[string]string
 }
 
-var (
-	// quick-and-dirty import finders for JS and CSS
-	rxJSImport  = regexp.MustCompile(`depends\([\t\s]*["']([^"']+)["'][\t\s]*\)[\t\s]*;?`)
-	rxCSSImport = regexp.MustCompile(`@depends[\t\s]+"([^"']+)"[\t\s]*;`)
-)
+// Import is a single dependency reference in a source file
+type Import struct {
+	Spec string // dependency as written in source
+	Path string // resolved absolute path
+	Line int    // 1-based line of the reference, 0 if unknown
+	Col  int    // 1-based column of the reference, 0 if unknown
+
+	// bare is true for ES module specifiers such as "lodash-es",
+	// which are dropped when the Resolver cannot resolve them
+	bare bool
+}
+
+// Pos returns the position of imp in importer as "path:line:col"
+func (imp *Import) Pos(importer string) string {
+	if imp.Line == 0 {
+		return importer
+	}
+	return fmt.Sprintf("%s:%d:%d", importer, imp.Line, imp.Col)
+}
 
 // ReadFrom reads content and deps from io.Reader
 func (source *Source) ReadFrom(r io.Reader) error {
@@ -42,40 +93,83 @@ func (source *Source) ReadFrom(r io.Reader) error {
 	}
 
 	source.Deps = []string{}
+	source.Imports = []Import{}
+	source.Assets = nil
+	source.Provides = nil
 	source.Content = data
 	source.Processed = data
 
-	var imports []string
-
 	switch source.Ext {
 	case ".js":
-		stmts := rxJSImport.FindAllStringSubmatch(string(source.Content), -1)
-		for _, stmt := range stmts {
-			imports = append(imports, stmt[1])
-		}
+		js := scanJS(source.Content, source.aliases)
+		source.Imports = js.Imports
+		source.Provides = js.Provides
+		source.Module, source.CommonJS, source.UMD = js.Module, js.CommonJS, js.UMD
 	case ".css":
-		stmts := rxCSSImport.FindAllStringSubmatch(string(source.Content), -1)
-		for _, stmt := range stmts {
-			imports = append(imports, stmt[1])
+		var assets []Import
+		source.Imports, assets = cssImports(source.Content, source.aliases)
+		for _, asset := range assets {
+			source.Assets = appendUnique(source.Assets, resolvePath(source.Path, aliasSpec(source.aliases, asset.Spec)))
 		}
 	case ".html":
-		//TODO: implement
+		source.Imports = htmlImports(source.Content, source.aliases)
 	default:
 		return ErrUnknownImport
 	}
 
-	for _, dep := range imports {
-		// relative import
-		if !strings.HasPrefix(dep, "/") {
-			dir := path.Dir(source.Path)
-			dep = path.Clean(path.Join(dir, dep))
+	for i := range source.Imports {
+		imp := &source.Imports[i]
+		imp.Path = resolvePath(source.Path, imp.Spec)
+		if source.CommonJS && path.Ext(imp.Path) == "" {
+			// require("./x") refers to "./x.js"
+			imp.Path += ".js"
+		}
+		if imp.bare {
+			// only the Resolver can find bare specifiers
+			continue
 		}
-		source.Deps = append(source.Deps, dep)
+		source.Deps = appendUnique(source.Deps, imp.Path)
 	}
 
 	return nil
 }
 
+// resolvePath resolves spec relative to the directory of importer
+func resolvePath(importer, spec string) string {
+	if strings.HasPrefix(spec, "/") {
+		return spec
+	}
+	dir := path.Dir(importer)
+	return path.Clean(path.Join(dir, spec))
+}
+
+// appendUnique appends value to list, unless it already contains it
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// ImportOf returns the import that resulted in dependency dep
+func (source *Source) ImportOf(dep string) *Import {
+	for i := range source.Imports {
+		if source.Imports[i].Path == dep {
+			return &source.Imports[i]
+		}
+	}
+	return nil
+}
+
+// lineCol converts byte offset in data to 1-based line and column
+func lineCol(data []byte, offset int) (line, col int) {
+	line = 1 + bytes.Count(data[:offset], []byte{'\n'})
+	col = offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, col
+}
+
 // Tag returns html tag that can be included in html
 func (src *Source) Tag() template.HTML {
 	u, err := url.Parse(src.Path)
@@ -17,6 +17,57 @@ func TestSourceReadJS(t *testing.T) {
 	}
 }
 
+func TestSourceReadJSSkipsCommentsAndLiterals(t *testing.T) {
+	src := &Source{Path: "/main.js", Ext: ".js"}
+	src.ReadFrom(bytes.NewBufferString(`depends("/A");
+// depends("/comment")
+/* depends("/block") */
+var s = 'depends("/string")', t = ` + "`depends(\"/template\") ${ depends(\"/B\") }`" + `;
+var rx = /depends("\/regexp")/, x = 4 / 2;
+  obj.depends("/method"); depends("C")
+`))
+
+	if !sameDeps(src.Deps, []string{"/A", "/B", "/C"}) {
+		t.Errorf("got %v", src.Deps)
+	}
+
+	imp := src.ImportOf("/C")
+	if imp == nil || imp.Pos(src.Path) != "/main.js:6:27" {
+		t.Errorf("invalid position %v", imp)
+	}
+}
+
+func TestSourceReadESModules(t *testing.T) {
+	src := &Source{Path: "/app/main.js", Ext: ".js"}
+	src.ReadFrom(bytes.NewBufferString(`import "./polyfill.js";
+import def, { a as b, "c" as d } from "./lib/a.js";
+import * as ns from '../shared/b.js';
+import lodash from "lodash";
+export { e } from "/c.js";
+export * from "./a.js";
+export const value = 1;
+export default function() { return import("./lazy.js"); }
+const meta = import.meta.url, name = obj.import("./method.js");
+`))
+
+	if !src.Module {
+		t.Errorf("expected module")
+	}
+	expected := []string{"/app/polyfill.js", "/app/lib/a.js", "/shared/b.js", "/c.js", "/app/a.js", "/app/lazy.js"}
+	if !sameDeps(src.Deps, expected) {
+		t.Errorf("got %v expected %v", src.Deps, expected)
+	}
+
+	classic := &Source{Path: "/main.js", Ext: ".js"}
+	classic.ReadFrom(bytes.NewBufferString(`depends("a.js"); import("./b.js");`))
+	if classic.Module {
+		t.Errorf("dynamic import should not make a module")
+	}
+	if !sameDeps(classic.Deps, []string{"/a.js", "/b.js"}) {
+		t.Errorf("got %v", classic.Deps)
+	}
+}
+
 func TestSourceReadCSS(t *testing.T) {
 	src := &Source{Ext: ".css"}
 	src.ReadFrom(bytes.NewBufferString(`
@@ -29,6 +80,44 @@ func TestSourceReadCSS(t *testing.T) {
 	}
 }
 
+func TestSourceReadCSSImportsAndAssets(t *testing.T) {
+	src := &Source{Path: "/css/main.css", Ext: ".css"}
+	src.ReadFrom(bytes.NewBufferString(`
+		@import "base.css";
+		@import url(../theme.css) screen;
+		@import url("https://example.com/x.css");
+		/* @import "commented.css"; url(commented.png) */
+		body { background: url(../img/bg.png) }
+		.icon { background: url( 'icon.svg#x' ), url(data:image/png;base64,AAAA) }
+		@font-face { src: url("../fonts/a.woff2?v=1") format("woff2"), url(../img/bg.png) }
+	`))
+
+	if !sameDeps(src.Deps, []string{"/css/base.css", "/theme.css"}) {
+		t.Errorf("got deps %v", src.Deps)
+	}
+	if !sameDeps(src.Assets, []string{"/img/bg.png", "/css/icon.svg", "/fonts/a.woff2"}) {
+		t.Errorf("got assets %v", src.Assets)
+	}
+}
+
+func TestSourceReadHTML(t *testing.T) {
+	src := &Source{Path: "/x/index.html", Ext: ".html"}
+	src.ReadFrom(bytes.NewBufferString(`
+		<!-- depends("/A.html") -->
+		<!-- <script src="commented.js"></script> -->
+		<link rel="stylesheet" href="B.css">
+		<link href="../C.html" rel="import">
+		<link rel="icon" href="favicon.ico">
+		<script src="D.js?v=1"></script>
+		<script src="https://example.com/E.js"></script>
+		<script>depends("F.js")</script>
+	`))
+
+	if !sameDeps(src.Deps, []string{"/A.html", "/x/B.css", "/C.html", "/x/D.js"}) {
+		t.Errorf("got %v", src.Deps)
+	}
+}
+
 func TestSourcePathSanitization(t *testing.T) {
 	src := &Source{Path: "/<script>=</script>.js", Ext: ".js"}
 	if src.Tag() != `<script src="/%3Cscript%3E=%3C/script%3E.js" type="text/javascript" >` {
@@ -0,0 +1,120 @@
+package livepkg
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// isGlob returns whether spec contains glob patterns
+func isGlob(spec string) bool {
+	return strings.ContainsAny(spec, "*?[")
+}
+
+// isDynamicSpec returns whether the files spec refers to can change without
+// the importer changing, i.e. spec is a glob or a directory
+func isDynamicSpec(spec string) bool {
+	return isGlob(spec) || strings.HasSuffix(spec, "/")
+}
+
+// dynamicPattern returns the absolute pattern of a glob or directory spec
+// in importer, directories end with "/"
+func dynamicPattern(importer, spec string) string {
+	pattern := resolvePath(importer, spec)
+	if !isGlob(spec) {
+		pattern = strings.TrimSuffix(pattern, "/") + "/"
+	}
+	return pattern
+}
+
+// globMatches returns whether file matches the absolute glob pattern
+func globMatches(pattern, file string) bool {
+	return isGlob(pattern) && globMatch(
+		strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+		strings.Split(strings.TrimPrefix(file, "/"), "/"))
+}
+
+// globFiles returns sorted list of files in root that match pattern.
+// Pattern is an absolute path, where "*" matches any sequence of characters
+// except "/" and "**" matches any number of directories.
+func globFiles(root http.FileSystem, pattern string) []string {
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+
+	// directory that doesn't contain any patterns
+	base := 0
+	for base < len(segments)-1 && !isGlob(segments[base]) {
+		base++
+	}
+	dir := "/" + strings.Join(segments[:base], "/")
+
+	depth := len(segments) - base
+	for _, segment := range segments[base:] {
+		if segment == "**" {
+			depth = -1
+		}
+	}
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	matches := []string{}
+	walkFiles(root, dir, depth, nil, func(file string) {
+		rel := strings.Split(strings.TrimPrefix(file, prefix), "/")
+		if globMatch(segments[base:], rel) {
+			matches = append(matches, file)
+		}
+	})
+	sort.Strings(matches)
+	return matches
+}
+
+// walkFiles calls fn for all files in dir up to depth levels deep,
+// negative depth means no limit. Hidden directories and directories
+// for which skip returns true are not walked.
+func walkFiles(root http.FileSystem, dir string, depth int, skip func(dir string) bool, fn func(file string)) {
+	if depth == 0 {
+		return
+	}
+	file, err := root.Open(dir)
+	if err != nil {
+		return
+	}
+	infos, err := file.Readdir(-1)
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") || skip != nil && skip(name) {
+				continue
+			}
+			walkFiles(root, name, depth-1, skip, fn)
+		} else {
+			fn(name)
+		}
+	}
+}
+
+// globMatch returns whether path segments match pattern segments
+func globMatch(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if globMatch(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); !ok || err != nil {
+		return false
+	}
+	return globMatch(pattern[1:], segments[1:])
+}
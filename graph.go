@@ -0,0 +1,186 @@
+package livepkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Graph is the dependency graph of a bundle
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a file in the dependency graph
+type GraphNode struct {
+	Path    string `json:"path"`
+	Ext     string `json:"ext"`
+	Missing bool   `json:"missing,omitempty"` // file couldn't be loaded
+	Cycle   bool   `json:"cycle,omitempty"`   // file is part of a cycle
+}
+
+// GraphEdge is a dependency of From on To
+type GraphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Ext   string `json:"ext"`             // extension of To
+	Cycle bool   `json:"cycle,omitempty"` // edge is part of a cycle
+}
+
+// graphColors contains the colors of edges by extension
+var graphColors = map[string]string{
+	".js":   "#c9a400",
+	".css":  "#264de4",
+	".html": "#e34c26",
+}
+
+const (
+	graphDefaultColor = "#888888"
+	graphCycleColor   = "#d62728"
+)
+
+// edgeColor returns the color of edge
+func edgeColor(edge GraphEdge) string {
+	if edge.Cycle {
+		return graphCycleColor
+	}
+	if color, ok := graphColors[edge.Ext]; ok {
+		return color
+	}
+	return graphDefaultColor
+}
+
+// Graph returns the dependency graph of the sources. Nodes are in the
+// sorted order of the sources followed by missing files.
+func (b *Bundle) Graph() *Graph {
+	snap := b.Snapshot()
+
+	cycleNodes := make(map[string]bool)
+	cycleEdges := make(map[[2]string]bool)
+	eachError(snap.errs, func(err error) {
+		if cycle, ok := err.(*CycleError); ok {
+			for _, c := range cycle.Cycles {
+				for _, path := range c.Paths {
+					cycleNodes[path] = true
+				}
+				for _, edge := range c.Edges {
+					cycleEdges[[2]string{edge.Path, edge.Dep}] = true
+				}
+			}
+		}
+	})
+
+	graph := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	missing := make(map[string]bool)
+	for _, src := range snap.Sources {
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			Path:  src.Path,
+			Ext:   src.Ext,
+			Cycle: cycleNodes[src.Path],
+		})
+		for _, dep := range src.Deps {
+			if snap.Source(dep) == nil {
+				missing[dep] = true
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{
+				From:  src.Path,
+				To:    dep,
+				Ext:   filepath.Ext(dep),
+				Cycle: cycleEdges[[2]string{src.Path, dep}],
+			})
+		}
+	}
+
+	missingPaths := []string{}
+	for path := range missing {
+		missingPaths = append(missingPaths, path)
+	}
+	sort.Strings(missingPaths)
+	for _, path := range missingPaths {
+		graph.Nodes = append(graph.Nodes, GraphNode{Path: path, Ext: filepath.Ext(path), Missing: true})
+	}
+	return graph
+}
+
+// WriteJSON writes the graph as JSON
+func (graph *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(graph)
+}
+
+// WriteDOT writes the graph in Graphviz DOT format. Edges are colored by
+// extension, cycles are red and missing files are dashed.
+func (graph *Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "digraph livepkg {")
+	fmt.Fprintln(out, "\trankdir=LR;")
+	fmt.Fprintln(out, "\tnode [shape=box, fontname=\"monospace\"];")
+	for _, node := range graph.Nodes {
+		attrs := []string{}
+		if node.Missing {
+			attrs = append(attrs, "style=dashed")
+		}
+		if node.Cycle {
+			attrs = append(attrs, "color=\""+graphCycleColor+"\"", "penwidth=2")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(out, "\t%s [%s];\n", strconv.Quote(node.Path), strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(out, "\t%s;\n", strconv.Quote(node.Path))
+		}
+	}
+	for _, edge := range graph.Edges {
+		attrs := "color=\"" + edgeColor(edge) + "\""
+		if edge.Cycle {
+			attrs += ", penwidth=2"
+		}
+		fmt.Fprintf(out, "\t%s -> %s [%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), attrs)
+	}
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. Edges are colored
+// by extension, cycles are red and missing files are dashed.
+func (graph *Graph) WriteMermaid(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	ids := make(map[string]string, len(graph.Nodes))
+	fmt.Fprintln(out, "graph LR")
+	for i, node := range graph.Nodes {
+		id := "n" + strconv.Itoa(i)
+		ids[node.Path] = id
+
+		class := ""
+		switch {
+		case node.Missing:
+			class = ":::missing"
+		case node.Cycle:
+			class = ":::cycle"
+		}
+		fmt.Fprintf(out, "\t%s[\"%s\"]%s\n", id, strings.Replace(node.Path, "\"", "#quot;", -1), class)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(out, "\t%s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+	for i, edge := range graph.Edges {
+		style := "stroke:" + edgeColor(edge)
+		if edge.Cycle {
+			style += ",stroke-width:2px"
+		}
+		fmt.Fprintf(out, "\tlinkStyle %d %s\n", i, style)
+	}
+	fmt.Fprintln(out, "\tclassDef missing stroke-dasharray:5 5")
+	fmt.Fprintln(out, "\tclassDef cycle stroke:"+graphCycleColor+",stroke-width:2px")
+
+	return out.Flush()
+}
@@ -0,0 +1,89 @@
+package livepkg
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rxHTMLDepends finds depends in html comments, e.g. <!-- depends("x.html") -->
+var rxHTMLDepends = regexp.MustCompile(`^[\t\s]*depends\([\t\s]*["']([^"']+)["'][\t\s]*\)[\t\s]*;?[\t\s]*$`)
+
+// htmlImports finds scripts, stylesheets, imports and depends comments in html,
+// references starting with a prefix in aliases are always local
+func htmlImports(data []byte, aliases map[string]string) []Import {
+	imports := []Import{}
+	local := func(ref string) bool { return isLocal(ref) || isAliased(aliases, ref) }
+
+	offset := 0
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := z.Next()
+		start := offset
+		offset += len(z.Raw())
+		add := func(spec string) {
+			line, col := lineCol(data, start)
+			imports = append(imports, Import{Spec: spec, Line: line, Col: col})
+		}
+
+		switch tt {
+		case html.ErrorToken:
+			return imports
+		case html.CommentToken:
+			match := rxHTMLDepends.FindSubmatch(z.Text())
+			if match != nil {
+				add(string(match[1]))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if !hasAttr {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "script":
+				if src := attrs["src"]; src != "" && local(src) {
+					add(trimQuery(src))
+				}
+			case "link":
+				href := attrs["href"]
+				if href == "" || !local(href) {
+					continue
+				}
+				switch strings.ToLower(attrs["rel"]) {
+				case "stylesheet", "import":
+					add(trimQuery(href))
+				}
+			}
+		}
+	}
+}
+
+// isLocal returns whether ref points to a file inside the bundle
+func isLocal(ref string) bool {
+	if strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") {
+		return false
+	}
+	if i := strings.IndexAny(ref, ":/?#"); i >= 0 && ref[i] == ':' {
+		// has a scheme, e.g. http: or data:
+		return false
+	}
+	return true
+}
+
+// trimQuery removes query and fragment from ref
+func trimQuery(ref string) string {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		return ref[:i]
+	}
+	return ref
+}
@@ -0,0 +1,122 @@
+package livepkg
+
+// Dependents returns sources that depend directly on path
+func (b *Bundle) Dependents(path string) []*Source {
+	return append([]*Source{}, b.Snapshot().Dependents(path)...)
+}
+
+// TransitiveDependents returns sources that depend on path directly or
+// through other sources, sorted by dependencies
+func (b *Bundle) TransitiveDependents(path string) []*Source {
+	snap := b.Snapshot()
+	found := snap.transitiveDependents(path)
+
+	dependents := []*Source{}
+	for _, src := range snap.Sources {
+		if found[src.Path] {
+			dependents = append(dependents, src)
+		}
+	}
+	return dependents
+}
+
+// transitiveDependents returns paths of the sources that depend on path
+func (snap *Snapshot) transitiveDependents(path string) map[string]bool {
+	found := make(map[string]bool)
+	unchecked := []string{path}
+	for len(unchecked) > 0 {
+		next := unchecked[len(unchecked)-1]
+		unchecked = unchecked[:len(unchecked)-1]
+		for _, src := range snap.Dependents(next) {
+			if !found[src.Path] {
+				found[src.Path] = true
+				unchecked = append(unchecked, src.Path)
+			}
+		}
+	}
+	return found
+}
+
+// Chain returns the shortest chain of dependencies from one of Main to
+// path, starting with the main file and ending with path, which may be
+// an asset. It returns nil when path is not used by any of Main.
+func (b *Bundle) Chain(path string) []string {
+	snap := b.Snapshot()
+
+	parent := make(map[string]string)
+	queue := []string{}
+	for _, main := range b.Main {
+		if _, ok := parent[main]; !ok && snap.Source(main) != nil {
+			parent[main] = ""
+			queue = append(queue, main)
+		}
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if next == path {
+			chain := []string{}
+			for p := path; p != ""; p = parent[p] {
+				chain = append([]string{p}, chain...)
+			}
+			return chain
+		}
+		src := snap.Source(next)
+		if src == nil {
+			// assets have no dependencies
+			continue
+		}
+		for _, dep := range append(sourcePaths(snap.Deps(next)), src.Assets...) {
+			if _, ok := parent[dep]; !ok {
+				parent[dep] = next
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// AffectedMains returns Main files that include the changed file
+// directly or through dependencies
+func (b *Bundle) AffectedMains(change *Change) []string {
+	path := change.path()
+	dependents := b.Snapshot().transitiveDependents(path)
+
+	mains := []string{}
+	for _, main := range b.Main {
+		if main == path || dependents[main] {
+			mains = append(mains, main)
+		}
+	}
+	return mains
+}
+
+// Impact describes which sources are affected when a file changes
+type Impact struct {
+	Path       string   `json:"path"`
+	Dependents []string `json:"dependents"` // sources that depend directly on Path
+	Transitive []string `json:"transitive"` // all sources that depend on Path
+	Chain      []string `json:"chain"`      // shortest chain from Main to Path
+	Mains      []string `json:"mains"`      // Main files that include Path
+}
+
+// Impact returns the impact of changing path
+func (b *Bundle) Impact(path string) *Impact {
+	return &Impact{
+		Path:       path,
+		Dependents: sourcePaths(b.Dependents(path)),
+		Transitive: sourcePaths(b.TransitiveDependents(path)),
+		Chain:      b.Chain(path),
+		Mains:      b.AffectedMains(&Change{Prev: &Source{Path: path}}),
+	}
+}
+
+// sourcePaths returns the paths of sources
+func sourcePaths(sources []*Source) []string {
+	result := make([]string, 0, len(sources))
+	for _, src := range sources {
+		result = append(result, src.Path)
+	}
+	return result
+}
@@ -0,0 +1,557 @@
+package livepkg
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// jsKind is the kind of a JavaScript token
+type jsKind int
+
+const (
+	jsEOF      jsKind = iota
+	jsIdent           // identifier or keyword
+	jsNumber          // numeric literal
+	jsString          // single or double quoted string
+	jsTemplate        // part of a template literal up to and including "${" or "`"
+	jsRegexp          // regular expression literal
+	jsPunct           // punctuator
+)
+
+// jsToken is a single JavaScript token, comments and whitespace are skipped
+type jsToken struct {
+	Kind jsKind
+	Text string
+
+	Offset int // byte offset in source
+	Line   int // 1-based line
+	Col    int // 1-based byte column
+
+	// Newline is true when there was a line terminator before the token
+	Newline bool
+}
+
+// is returns whether token is a punctuator or identifier with text
+func (tok jsToken) is(text string) bool {
+	return (tok.Kind == jsPunct || tok.Kind == jsIdent) && tok.Text == text
+}
+
+// jsLexer is a small JavaScript tokenizer that understands enough of the
+// language to skip comments, strings, template and regular expression literals
+type jsLexer struct {
+	src  []byte
+	pos  int
+	line int
+	col  int
+
+	prev  jsToken // last significant token
+	depth int     // curly brace depth
+	// templates contains brace depths where a template literal continues
+	templates []int
+}
+
+// newJSLexer returns a lexer for src
+func newJSLexer(src []byte) *jsLexer {
+	return &jsLexer{src: src, line: 1, col: 1}
+}
+
+// jsTokenize returns all tokens in src
+func jsTokenize(src []byte) []jsToken {
+	lx := newJSLexer(src)
+	tokens := []jsToken{}
+	for {
+		tok := lx.Next()
+		if tok.Kind == jsEOF {
+			return tokens
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// peek returns byte at offset from current position or 0
+func (lx *jsLexer) peek(offset int) byte {
+	if lx.pos+offset < len(lx.src) {
+		return lx.src[lx.pos+offset]
+	}
+	return 0
+}
+
+// advance moves n bytes forward, keeping track of lines and columns
+func (lx *jsLexer) advance(n int) {
+	for ; n > 0 && lx.pos < len(lx.src); n-- {
+		if lx.src[lx.pos] == '\n' {
+			lx.line++
+			lx.col = 1
+		} else {
+			lx.col++
+		}
+		lx.pos++
+	}
+}
+
+// skipSpace skips whitespace and comments and returns
+// whether a line terminator was encountered
+func (lx *jsLexer) skipSpace() (newline bool) {
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case c == '\n' || c == '\r':
+			newline = true
+			lx.advance(1)
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f':
+			lx.advance(1)
+		case c == '/' && lx.peek(1) == '/':
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.advance(1)
+			}
+		case c == '/' && lx.peek(1) == '*':
+			end := bytes.Index(lx.src[lx.pos+2:], []byte("*/"))
+			if end < 0 {
+				end = len(lx.src) - lx.pos - 2
+			} else {
+				end += 2
+			}
+			if bytes.ContainsAny(lx.src[lx.pos:lx.pos+2+end], "\n\r") {
+				newline = true
+			}
+			lx.advance(2 + end)
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRune(lx.src[lx.pos:])
+			if !unicode.IsSpace(r) && r != '\uFEFF' {
+				return newline
+			}
+			if r == '\u2028' || r == '\u2029' {
+				newline = true
+			}
+			lx.advance(size)
+		default:
+			return newline
+		}
+	}
+	return newline
+}
+
+// Next returns the next token
+func (lx *jsLexer) Next() jsToken {
+	newline := lx.skipSpace()
+
+	tok := jsToken{
+		Offset:  lx.pos,
+		Line:    lx.line,
+		Col:     lx.col,
+		Newline: newline,
+	}
+	if lx.pos >= len(lx.src) {
+		tok.Kind = jsEOF
+		return tok
+	}
+
+	start := lx.pos
+	c := lx.src[lx.pos]
+	switch {
+	case isJSIdentStart(lx.src[lx.pos:]) || c == '#' || c == '\\':
+		tok.Kind = jsIdent
+		_, size := utf8.DecodeRune(lx.src[lx.pos:])
+		lx.advance(size)
+		for lx.pos < len(lx.src) && isJSIdentPart(lx.src[lx.pos:]) {
+			_, size := utf8.DecodeRune(lx.src[lx.pos:])
+			lx.advance(size)
+		}
+	case isDigit(c) || (c == '.' && isDigit(lx.peek(1))):
+		tok.Kind = jsNumber
+		lx.number()
+	case c == '"' || c == '\'':
+		tok.Kind = jsString
+		lx.quoted(c)
+	case c == '`':
+		tok.Kind = jsTemplate
+		lx.advance(1)
+		lx.template()
+	case c == '}' && len(lx.templates) > 0 && lx.templates[len(lx.templates)-1] == lx.depth:
+		tok.Kind = jsTemplate
+		lx.templates = lx.templates[:len(lx.templates)-1]
+		lx.advance(1)
+		lx.template()
+	case c == '/' && lx.regexpAllowed():
+		tok.Kind = jsRegexp
+		lx.regexp()
+	default:
+		tok.Kind = jsPunct
+		lx.advance(len(jsPunctAt(lx.src[lx.pos:])))
+		switch lx.src[start] {
+		case '{':
+			lx.depth++
+		case '}':
+			lx.depth--
+		}
+	}
+
+	tok.Text = string(lx.src[start:lx.pos])
+	lx.prev = tok
+	return tok
+}
+
+// number reads a numeric literal
+func (lx *jsLexer) number() {
+	hex := lx.peek(0) == '0' && (lx.peek(1) == 'x' || lx.peek(1) == 'X')
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case isDigit(c) || isLetter(c) || c == '_' || c == '.':
+			lx.advance(1)
+			if !hex && (c == 'e' || c == 'E') && (lx.peek(0) == '+' || lx.peek(0) == '-') {
+				lx.advance(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// quoted reads a string literal that is quoted with q
+func (lx *jsLexer) quoted(q byte) {
+	lx.advance(1)
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch c {
+		case '\\':
+			lx.advance(2)
+		case q:
+			lx.advance(1)
+			return
+		case '\n':
+			// unterminated string
+			return
+		default:
+			lx.advance(1)
+		}
+	}
+}
+
+// template reads template literal content up to "${" or "`"
+func (lx *jsLexer) template() {
+	for lx.pos < len(lx.src) {
+		switch lx.src[lx.pos] {
+		case '\\':
+			lx.advance(2)
+		case '`':
+			lx.advance(1)
+			return
+		case '$':
+			if lx.peek(1) == '{' {
+				lx.advance(2)
+				lx.templates = append(lx.templates, lx.depth)
+				return
+			}
+			lx.advance(1)
+		default:
+			lx.advance(1)
+		}
+	}
+}
+
+// regexp reads a regular expression literal
+func (lx *jsLexer) regexp() {
+	lx.advance(1)
+	class := false
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case c == '\\':
+			lx.advance(2)
+			continue
+		case c == '\n':
+			// unterminated regexp
+			return
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			lx.advance(1)
+			for lx.pos < len(lx.src) && isJSIdentPart(lx.src[lx.pos:]) {
+				lx.advance(1)
+			}
+			return
+		}
+		lx.advance(1)
+	}
+}
+
+// regexpAllowed returns whether a "/" at current position starts
+// a regular expression literal instead of being a division
+func (lx *jsLexer) regexpAllowed() bool {
+	prev := lx.prev
+	switch prev.Kind {
+	case jsEOF:
+		return true
+	case jsNumber, jsString, jsRegexp:
+		return false
+	case jsTemplate:
+		// "${" continues with an expression
+		return strings.HasSuffix(prev.Text, "${")
+	case jsIdent:
+		return jsKeywordsBeforeExpr[prev.Text]
+	case jsPunct:
+		switch prev.Text {
+		case ")", "]", "}", "++", "--":
+			return false
+		}
+		return true
+	}
+	return true
+}
+
+// jsKeywordsBeforeExpr are keywords that can be followed by an expression
+var jsKeywordsBeforeExpr = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true,
+	"of": true, "new": true, "delete": true, "void": true, "throw": true,
+	"case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
+// jsPuncts is ordered so that longer punctuators are matched first
+var jsPuncts = []string{
+	">>>=",
+	"...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+}
+
+// jsPunctAt returns the punctuator at the start of src
+func jsPunctAt(src []byte) string {
+	for _, p := range jsPuncts {
+		if strings.HasPrefix(string(src[:min(len(src), 4)]), p) {
+			// "?." followed by a digit is a conditional
+			if p == "?." && len(src) > 2 && isDigit(src[2]) {
+				continue
+			}
+			return p
+		}
+	}
+	return string(src[:1])
+}
+
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+
+// isJSIdentStart returns whether src starts with an identifier start character
+func isJSIdentStart(src []byte) bool {
+	c := src[0]
+	if c < utf8.RuneSelf {
+		return isLetter(c) || c == '_' || c == '$'
+	}
+	r, _ := utf8.DecodeRune(src)
+	return unicode.IsLetter(r)
+}
+
+// isJSIdentPart returns whether src starts with an identifier character
+func isJSIdentPart(src []byte) bool {
+	c := src[0]
+	if c < utf8.RuneSelf {
+		return isLetter(c) || isDigit(c) || c == '_' || c == '$' || c == '\\'
+	}
+	r, _ := utf8.DecodeRune(src)
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r) || r == '\u200C' || r == '\u200D'
+}
+
+// jsUnquote returns the value of a string token
+func jsUnquote(tok jsToken) (string, bool) {
+	if tok.Kind != jsString || len(tok.Text) < 2 {
+		return "", false
+	}
+	text := tok.Text
+	if text[0] == '\'' {
+		body := text[1 : len(text)-1]
+		body = strings.Replace(body, `\'`, `'`, -1)
+		body = strings.Replace(body, `"`, `\"`, -1)
+		text = `"` + body + `"`
+	}
+	s, err := strconv.Unquote(text)
+	return s, err == nil
+}
+
+// jsCallArg returns the string argument of a call such as name("arg")
+// that starts at tokens[i]. Calls to methods with the same name are ignored.
+func jsCallArg(tokens []jsToken, i int, name string) (string, bool) {
+	if tokens[i].Kind != jsIdent || tokens[i].Text != name {
+		return "", false
+	}
+	if i > 0 && (tokens[i-1].is(".") || tokens[i-1].is("?.")) {
+		return "", false
+	}
+	if i+3 >= len(tokens) || !tokens[i+1].is("(") {
+		return "", false
+	}
+	arg, ok := jsUnquote(tokens[i+2])
+	if !ok || !(tokens[i+3].is(")") || tokens[i+3].is(",")) {
+		return "", false
+	}
+	return arg, true
+}
+
+// jsSource contains information found by scanning JavaScript source
+type jsSource struct {
+	Imports  []Import
+	Provides []string // package names declared with package("name", ...)
+	Module   bool     // source uses import or export statements
+	CommonJS bool     // source uses require or exports without UMD checks
+	UMD      bool     // source checks whether module, exports, define or require exist
+}
+
+// scanJS finds depends("...") and require("...") calls, static import and
+// export ... from statements, dynamic import("...") calls and package
+// declarations in JavaScript source. Sources that check whether require or
+// exports exist, like UMD wrappers do, are not considered CommonJS.
+// Dependencies from require calls are only returned for CommonJS sources.
+// Specs starting with a prefix in aliases are always dependencies.
+func scanJS(data []byte, aliases map[string]string) *jsSource {
+	js := &jsSource{
+		Imports:  []Import{},
+		Provides: []string{},
+	}
+	requires := []Import{}
+	umd, commonjs := false, false
+
+	tokens := jsTokenize(data)
+	for i, tok := range tokens {
+		if spec, ok := jsCallArg(tokens, i, "depends"); ok {
+			js.Imports = append(js.Imports, Import{
+				Spec: spec,
+				Line: tok.Line,
+				Col:  tok.Col,
+			})
+			continue
+		}
+		if name, ok := jsCallArg(tokens, i, "package"); ok {
+			js.Provides = appendUnique(js.Provides, name)
+			continue
+		}
+		if spec, ok := jsCallArg(tokens, i, "require"); ok {
+			commonjs = true
+			if isLocal(spec) || isAliased(aliases, spec) {
+				requires = append(requires, Import{
+					Spec: spec,
+					Line: tok.Line,
+					Col:  tok.Col,
+				})
+			}
+			continue
+		}
+
+		if tok.Kind != jsIdent || i > 0 && (tokens[i-1].is(".") || tokens[i-1].is("?.")) {
+			continue
+		}
+		next := jsToken{}
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+
+		switch tok.Text {
+		case "typeof":
+			switch next.Text {
+			case "module", "exports", "define", "require":
+				umd = umd || next.Kind == jsIdent
+			}
+		case "module":
+			if next.is(".") && i+2 < len(tokens) && tokens[i+2].is("exports") {
+				commonjs = true
+			}
+		case "exports":
+			if next.is(".") || next.is("=") || next.is("[") {
+				commonjs = true
+			}
+		case "import":
+			if next.is("(") || next.is(".") {
+				// dynamic import("...") or import.meta
+				js.Module = js.Module || next.is(".")
+				if spec, ok := jsCallArg(tokens, i, "import"); ok && (isLocal(spec) || isAliased(aliases, spec)) {
+					js.Imports = append(js.Imports, Import{
+						Spec: spec,
+						Line: tok.Line,
+						Col:  tok.Col,
+						bare: !isModuleSpec(spec) && !isAliased(aliases, spec),
+					})
+				}
+				continue
+			}
+			fallthrough
+		case "export":
+			js.Module = true
+			if spec, ok := jsModuleFrom(tokens, i); ok && (isLocal(spec) || isAliased(aliases, spec)) {
+				js.Imports = append(js.Imports, Import{
+					Spec: spec,
+					Line: tok.Line,
+					Col:  tok.Col,
+					bare: !isModuleSpec(spec) && !isAliased(aliases, spec),
+				})
+			}
+		}
+	}
+
+	js.CommonJS = commonjs && !umd && !js.Module
+	js.UMD = umd && !js.Module
+	if js.CommonJS {
+		imports := append(js.Imports, requires...)
+		sort.SliceStable(imports, func(i, k int) bool {
+			if imports[i].Line != imports[k].Line {
+				return imports[i].Line < imports[k].Line
+			}
+			return imports[i].Col < imports[k].Col
+		})
+		js.Imports = imports
+	}
+	return js
+}
+
+// jsModuleFrom returns the module specifier of import or export statement
+// that starts at tokens[i]
+func jsModuleFrom(tokens []jsToken, i int) (string, bool) {
+	if tokens[i].Text == "import" && i+1 < len(tokens) {
+		// import "module"
+		if spec, ok := jsUnquote(tokens[i+1]); ok {
+			return spec, true
+		}
+	}
+
+	// skip import or export clause until "from"
+	braces := 0
+	for i++; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.is("{"):
+			braces++
+		case tok.is("}"):
+			braces--
+			if braces < 0 || i+1 >= len(tokens) || !tokens[i+1].is("from") {
+				return "", false
+			}
+		case braces > 0:
+			// names, strings, "as" and "," are allowed inside braces
+			if tok.Kind != jsIdent && tok.Kind != jsString && !tok.is(",") {
+				return "", false
+			}
+		case tok.is("from"):
+			if i+1 < len(tokens) {
+				return jsUnquote(tokens[i+1])
+			}
+			return "", false
+		case tok.Kind == jsIdent && tok.Text != "default" && tok.Text != "function" &&
+			tok.Text != "class" && tok.Text != "const" && tok.Text != "let" && tok.Text != "var":
+		case tok.is("*") || tok.is(","):
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isModuleSpec returns whether an ES module specifier refers to a file
+// relative to the importer or the root instead of being a bare specifier
+func isModuleSpec(spec string) bool {
+	return strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") ||
+		(strings.HasPrefix(spec, "/") && !strings.HasPrefix(spec, "//"))
+}
@@ -0,0 +1,752 @@
+package livepkg
+
+import "strings"
+
+// minifyJS removes comments and whitespace from JavaScript and shortens
+// names of local variables. It returns the minified code and a source map
+// from minified code to data.
+//
+// Renaming is conservative: only variables declared inside functions are
+// renamed and functions that use eval, with, classes or destructuring
+// declarations keep their names together with all the enclosing functions.
+// Names declared in blocks with let, const or catch are not renamed at all.
+func minifyJS(data []byte) ([]byte, *SourceMap) {
+	an := analyzeJS(jsTokenize(data))
+
+	w := &mapWriter{}
+	var prev jsToken
+	for i, tok := range an.tokens {
+		text, name := tok.Text, ""
+		if short, ok := an.rename(i); ok {
+			text, name = short, tok.Text
+		}
+
+		if i > 0 {
+			if tok.Newline && jsNeedsNewline(prev, tok) {
+				w.WriteString("\n")
+			} else if jsNeedsSpace(prev, text) {
+				w.WriteString(" ")
+			}
+		}
+
+		w.mark(tok.Line-1, tok.Col-1, name)
+		if name != "" && an.shorthand[i] {
+			w.WriteString(name + ":")
+		}
+		w.WriteString(text)
+
+		prev = tok
+		prev.Text = text
+	}
+
+	return w.buf.Bytes(), w.Map()
+}
+
+// jsNeedsNewline returns whether a line terminator between prev and tok
+// has to be kept because of automatic semicolon insertion
+func jsNeedsNewline(prev, tok jsToken) bool {
+	// restricted productions
+	if prev.Kind == jsIdent {
+		switch prev.Text {
+		case "return", "break", "continue", "throw", "yield", "async":
+			return true
+		}
+	}
+	if tok.is("++") || tok.is("--") {
+		return true
+	}
+
+	// prev cannot end a statement
+	switch prev.Kind {
+	case jsPunct:
+		switch prev.Text {
+		case ")", "]", "}", "++", "--":
+		default:
+			return false
+		}
+	case jsIdent:
+		if jsKeywordsBeforeExpr[prev.Text] {
+			return false
+		}
+	case jsTemplate:
+		if strings.HasSuffix(prev.Text, "${") {
+			return false
+		}
+	}
+
+	// tok continues the previous expression
+	switch tok.Kind {
+	case jsPunct:
+		switch tok.Text {
+		case "{", "!", "~":
+			return true
+		}
+		return false
+	case jsTemplate:
+		return false
+	}
+	return true
+}
+
+// jsNeedsSpace returns whether text must be separated from prev
+func jsNeedsSpace(prev jsToken, text string) bool {
+	last := prev.Text[len(prev.Text)-1]
+	first := text[0]
+
+	switch {
+	case isJSWordByte(last) && isJSWordByte(first):
+		return true
+	case prev.Kind == jsRegexp && isJSWordByte(first):
+		return true
+	case prev.Kind == jsNumber && first == '.' && !strings.ContainsAny(prev.Text, ".eExXbBoO"):
+		return true
+	case (last == '+' || last == '-') && first == last:
+		return true
+	case last == '/' && (first == '/' || first == '*'):
+		return true
+	case last == '<' && first == '!':
+		return true
+	case strings.HasSuffix(prev.Text, "--") && first == '>':
+		return true
+	}
+	return false
+}
+
+// isJSWordByte returns whether c can be part of an identifier or number
+func isJSWordByte(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_' || c == '$' || c == '\\' || c >= 0x80
+}
+
+// jsReserved contains words that are never used as variable names
+var jsReserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true,
+	"continue": true, "debugger": true, "default": true, "delete": true,
+	"do": true, "else": true, "enum": true, "export": true, "extends": true,
+	"false": true, "finally": true, "for": true, "function": true, "if": true,
+	"import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true, "let": true, "await": true,
+	"static": true, "implements": true, "interface": true, "package": true,
+	"private": true, "protected": true, "public": true,
+}
+
+// jsScope is a function scope
+type jsScope struct {
+	parent   *jsScope
+	children []*jsScope
+
+	declared map[string]bool
+	order    []string // declared names in order of declaration
+
+	// unsafe is set when names in the scope cannot be renamed
+	unsafe  bool
+	renamed map[string]string
+	// kept contains names declared in blocks, blocks are not modeled
+	// as scopes, so these names are not renamed in any enclosing scope
+	kept map[string]bool
+}
+
+// newScope creates a new child scope
+func (scope *jsScope) newScope() *jsScope {
+	child := &jsScope{
+		parent:   scope,
+		declared: make(map[string]bool),
+	}
+	if scope != nil {
+		scope.children = append(scope.children, child)
+	}
+	return child
+}
+
+// declare adds a declared name to the scope
+func (scope *jsScope) declare(name string) {
+	if jsReserved[name] || strings.HasPrefix(name, "#") {
+		return
+	}
+	if !scope.declared[name] {
+		scope.declared[name] = true
+		scope.order = append(scope.order, name)
+	}
+}
+
+// keep disables renaming name in scope and all enclosing scopes
+func (scope *jsScope) keep(name string) {
+	for ; scope != nil; scope = scope.parent {
+		if scope.kept == nil {
+			scope.kept = make(map[string]bool)
+		}
+		scope.kept[name] = true
+	}
+}
+
+// markUnsafe disables renaming in scope and all enclosing scopes
+func (scope *jsScope) markUnsafe() {
+	for ; scope != nil; scope = scope.parent {
+		scope.unsafe = true
+	}
+}
+
+// jsContext is an open bracket during analysis
+type jsContext struct {
+	open    int  // index of the bracket
+	object  bool // brace is an object literal
+	ternary int  // number of open "?" in the bracket
+	cases   int  // number of "case" waiting for ":"
+}
+
+// jsAnalysis contains information about variables in tokens
+type jsAnalysis struct {
+	tokens []jsToken
+	match  []int // index of the matching bracket, -1 if none
+
+	root *jsScope
+	// refs maps identifier tokens to the scope they appear in
+	refs map[int]*jsScope
+	// shorthand contains identifiers that are shorthand properties
+	shorthand map[int]bool
+	// handled contains tokens that have been already processed
+	handled map[int]bool
+	// pending contains scopes by their start index
+	pending map[int][]*jsScopeRange
+}
+
+// jsScopeRange is a scope with the range of tokens it covers
+type jsScopeRange struct {
+	scope *jsScope
+	end   int
+}
+
+// analyzeJS finds variable declarations and references in tokens
+// and assigns short names to local variables
+func analyzeJS(tokens []jsToken) *jsAnalysis {
+	an := &jsAnalysis{
+		tokens:    tokens,
+		match:     jsMatchBrackets(tokens),
+		refs:      make(map[int]*jsScope),
+		shorthand: make(map[int]bool),
+		handled:   make(map[int]bool),
+		pending:   make(map[int][]*jsScopeRange),
+	}
+	an.root = (*jsScope)(nil).newScope()
+	an.root.unsafe = true
+
+	an.walk()
+
+	used := make(map[string]bool)
+	for _, tok := range tokens {
+		if tok.Kind == jsIdent {
+			used[tok.Text] = true
+		}
+	}
+	assignNames(an.root, used, map[string]bool{})
+
+	return an
+}
+
+// rename returns the new name for token i
+func (an *jsAnalysis) rename(i int) (string, bool) {
+	scope, ok := an.refs[i]
+	if !ok {
+		return "", false
+	}
+	name := an.tokens[i].Text
+	for ; scope != nil; scope = scope.parent {
+		if scope.declared[name] {
+			short, ok := scope.renamed[name]
+			return short, ok
+		}
+	}
+	return "", false
+}
+
+// tok returns token at i or an EOF token
+func (an *jsAnalysis) tok(i int) jsToken {
+	if i < 0 || i >= len(an.tokens) {
+		return jsToken{Kind: jsEOF}
+	}
+	return an.tokens[i]
+}
+
+// walk goes through all tokens and collects declarations and references
+func (an *jsAnalysis) walk() {
+	scopes := []*jsScopeRange{{scope: an.root, end: len(an.tokens)}}
+	contexts := []*jsContext{{open: -1}}
+
+	for i := 0; i < len(an.tokens); i++ {
+		for len(scopes) > 1 && scopes[len(scopes)-1].end < i {
+			scopes = scopes[:len(scopes)-1]
+		}
+		scopes = append(scopes, an.pending[i]...)
+
+		scope := scopes[len(scopes)-1].scope
+		ctx := contexts[len(contexts)-1]
+		tok := an.tokens[i]
+		prev, next := an.tok(i-1), an.tok(i+1)
+
+		switch tok.Kind {
+		case jsTemplate:
+			if strings.HasPrefix(tok.Text, "}") && len(contexts) > 1 {
+				contexts = contexts[:len(contexts)-1]
+			}
+			if strings.HasSuffix(tok.Text, "${") {
+				contexts = append(contexts, &jsContext{})
+			}
+			continue
+		case jsPunct:
+		case jsIdent:
+		default:
+			continue
+		}
+
+		if tok.Kind == jsPunct {
+			switch tok.Text {
+			case "{":
+				contexts = append(contexts, &jsContext{open: i, object: an.isObjectLiteral(i, ctx)})
+			case "(":
+				if an.isArrowParams(i) {
+					scopes = append(scopes, an.arrow(i, an.match[i], scope))
+				}
+				contexts = append(contexts, &jsContext{open: i})
+			case "[":
+				contexts = append(contexts, &jsContext{open: i})
+			case "}", ")", "]":
+				if len(contexts) > 1 {
+					contexts = contexts[:len(contexts)-1]
+				}
+			case "?":
+				ctx.ternary++
+			case ":":
+				if ctx.ternary > 0 {
+					ctx.ternary--
+				} else if ctx.cases > 0 {
+					ctx.cases--
+				}
+			}
+			continue
+		}
+
+		if an.handled[i] || strings.HasPrefix(tok.Text, "#") {
+			continue
+		}
+		if prev.is(".") || prev.is("?.") {
+			// property access
+			continue
+		}
+
+		switch tok.Text {
+		case "function":
+			an.function(i, scope)
+			continue
+		case "var", "const", "let":
+			if tok.Text != "let" || next.Kind == jsIdent || next.is("{") || next.is("[") {
+				// let and const outside of the function body are block scoped
+				block := tok.Text != "var" && (ctx.open < 0 || an.match[ctx.open] != scopes[len(scopes)-1].end)
+				an.declarations(i, scope, block)
+			}
+			continue
+		case "catch":
+			if next.is("(") {
+				if param := an.tok(i + 2); param.Kind == jsIdent && an.tok(i+3).is(")") {
+					scope.declare(param.Text)
+					scope.keep(param.Text)
+					an.refs[i+2] = scope
+					an.handled[i+2] = true
+				} else {
+					scope.markUnsafe()
+				}
+			}
+			continue
+		case "class", "with", "eval":
+			scope.markUnsafe()
+			continue
+		case "case":
+			ctx.cases++
+			continue
+		case "default":
+			if next.is(":") {
+				ctx.cases++
+			}
+			continue
+		case "async":
+			if next.is("function") || next.Kind == jsIdent && an.tok(i+2).is("=>") || next.is("(") && an.isArrowParams(i+1) {
+				continue
+			}
+		}
+		if jsReserved[tok.Text] {
+			continue
+		}
+		if (prev.is("break") || prev.is("continue")) && !tok.Newline {
+			// label
+			continue
+		}
+		if next.is("=>") {
+			// the body starts after "=>"
+			an.pending[i+1] = append(an.pending[i+1], an.arrow(i, i, scope))
+			continue
+		}
+		if next.is(":") && ctx.ternary == 0 && ctx.cases == 0 {
+			// object key or label
+			continue
+		}
+
+		if ctx.object && an.isKeyPosition(i) {
+			switch {
+			case next.is("("):
+				an.method(i+1, scope)
+				continue
+			case next.is(",") || next.is("}") || next.is("="):
+				an.shorthand[i] = true
+			default:
+				// get, set, async or key followed by something unexpected
+				continue
+			}
+		}
+
+		an.refs[i] = scope
+	}
+}
+
+// isKeyPosition returns whether token i is at a property name position
+// of an object literal
+func (an *jsAnalysis) isKeyPosition(i int) bool {
+	prev := an.tok(i - 1)
+	if prev.is("{") || prev.is(",") {
+		return true
+	}
+	if prev.is("*") || prev.is("get") || prev.is("set") || prev.is("async") || prev.is("static") {
+		before := an.tok(i - 2)
+		return before.is("{") || before.is(",")
+	}
+	return false
+}
+
+// isObjectLiteral returns whether brace at i starts an object literal
+func (an *jsAnalysis) isObjectLiteral(i int, ctx *jsContext) bool {
+	prev := an.tok(i - 1)
+	switch prev.Kind {
+	case jsEOF:
+		return false
+	case jsTemplate:
+		return strings.HasSuffix(prev.Text, "${")
+	case jsIdent:
+		return jsKeywordsBeforeExpr[prev.Text] && prev.Text != "do" && prev.Text != "else"
+	case jsPunct:
+		switch prev.Text {
+		case "{", "}", ";", ")", "]", "=>", "++", "--":
+			return false
+		case ":":
+			// ternary and object values contain objects, labels and cases blocks
+			if ctx.object {
+				return true
+			}
+			return an.isExpressionColon(i - 1)
+		}
+		return true
+	}
+	return false
+}
+
+// isExpressionColon returns whether ":" at i is part of a conditional
+func (an *jsAnalysis) isExpressionColon(i int) bool {
+	depth := 0
+	for k := i - 1; k >= 0; k-- {
+		tok := an.tokens[k]
+		if tok.Kind != jsPunct {
+			if tok.is("case") && depth == 0 {
+				return false
+			}
+			continue
+		}
+		switch tok.Text {
+		case ")", "]", "}":
+			depth++
+		case "(", "[", "{":
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case "?":
+			if depth == 0 {
+				return true
+			}
+		case ";":
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// function handles function keyword at i
+func (an *jsAnalysis) function(i int, outer *jsScope) {
+	k := i + 1
+	if an.tok(k).is("*") {
+		k++
+	}
+	name := -1
+	if tok := an.tok(k); tok.Kind == jsIdent && !jsReserved[tok.Text] {
+		name = k
+		k++
+	}
+	if !an.tok(k).is("(") {
+		return
+	}
+
+	scope := an.params(k, outer)
+	if scope == nil {
+		return
+	}
+
+	if name >= 0 {
+		an.handled[name] = true
+		if an.isDeclaration(i) {
+			outer.declare(an.tokens[name].Text)
+			an.refs[name] = outer
+		} else {
+			scope.declare(an.tokens[name].Text)
+			an.refs[name] = scope
+		}
+	}
+}
+
+// isDeclaration returns whether function keyword at i is a declaration
+func (an *jsAnalysis) isDeclaration(i int) bool {
+	prev := an.tok(i - 1)
+	if prev.is("async") {
+		prev = an.tok(i - 2)
+	}
+	switch prev.Kind {
+	case jsEOF:
+		return true
+	case jsPunct:
+		switch prev.Text {
+		case ";", "{", "}", ")":
+			return true
+		}
+	case jsIdent:
+		switch prev.Text {
+		case "else", "do", "export", "default":
+			return true
+		}
+	}
+	return false
+}
+
+// method handles object literal method with parameters starting at i
+func (an *jsAnalysis) method(i int, outer *jsScope) {
+	an.params(i, outer)
+}
+
+// params creates a scope for function with parameters starting at i
+// followed by a body in braces, nil is returned when the body is missing
+func (an *jsAnalysis) params(i int, outer *jsScope) *jsScope {
+	end := an.match[i]
+	if end < 0 || !an.tok(end+1).is("{") || an.match[end+1] < 0 {
+		return nil
+	}
+
+	scope := outer.newScope()
+	an.declareParams(i, end, scope)
+	an.pending[i] = append(an.pending[i], &jsScopeRange{scope: scope, end: an.match[end+1]})
+	return scope
+}
+
+// isArrowParams returns whether parenthesis at i contains arrow function parameters
+func (an *jsAnalysis) isArrowParams(i int) bool {
+	end := an.match[i]
+	return end > i && an.tok(end+1).is("=>")
+}
+
+// arrow creates a scope for an arrow function with parameters from start to end
+func (an *jsAnalysis) arrow(start, end int, outer *jsScope) *jsScopeRange {
+	scope := outer.newScope()
+	if start == end {
+		scope.declare(an.tokens[start].Text)
+		an.refs[start] = scope
+		an.handled[start] = true
+	} else {
+		an.declareParams(start, end, scope)
+	}
+
+	body := end + 2
+	last := an.expressionEnd(body)
+	if an.tok(body).is("{") && an.match[body] >= 0 {
+		last = an.match[body]
+	}
+	return &jsScopeRange{scope: scope, end: last}
+}
+
+// declareParams declares parameters in parentheses from open to close
+func (an *jsAnalysis) declareParams(open, close int, scope *jsScope) {
+	for k := open + 1; k < close; k++ {
+		tok := an.tokens[k]
+		prev := an.tok(k - 1)
+		atStart := k == open+1 || prev.is(",") || prev.is("...")
+
+		switch {
+		case tok.is("(") || tok.is("[") || tok.is("{"):
+			if atStart {
+				// destructuring
+				scope.markUnsafe()
+			}
+			if an.match[k] > k {
+				k = an.match[k]
+			}
+		case tok.Kind == jsIdent && atStart:
+			scope.declare(tok.Text)
+			an.refs[k] = scope
+			an.handled[k] = true
+		}
+	}
+}
+
+// declarations handles var, let and const declarations starting at i,
+// block scoped names are kept
+func (an *jsAnalysis) declarations(i int, scope *jsScope, block bool) {
+	k := i + 1
+	for k < len(an.tokens) {
+		tok := an.tokens[k]
+		switch {
+		case tok.Kind == jsIdent && !jsReserved[tok.Text]:
+			scope.declare(tok.Text)
+			if block {
+				scope.keep(tok.Text)
+			}
+			an.refs[k] = scope
+			an.handled[k] = true
+		case tok.is("{") || tok.is("["):
+			scope.markUnsafe()
+		default:
+			return
+		}
+
+		// find next declarator
+		for k++; k < len(an.tokens); k++ {
+			tok := an.tokens[k]
+			if tok.Newline && jsNeedsNewline(an.tokens[k-1], tok) && !tok.is(",") {
+				return
+			}
+			if tok.is(",") {
+				k++
+				break
+			}
+			switch {
+			case tok.is(";"), tok.is("in"), tok.is("of"), tok.is(")"), tok.is("]"), tok.is("}"):
+				return
+			case tok.Kind == jsTemplate && strings.HasPrefix(tok.Text, "}"):
+				return
+			}
+			if an.match[k] > k {
+				k = an.match[k]
+			}
+		}
+	}
+}
+
+// expressionEnd returns index of the last token of expression starting at i
+func (an *jsAnalysis) expressionEnd(i int) int {
+	k := i
+	for ; k < len(an.tokens); k++ {
+		tok := an.tokens[k]
+		if k > i && tok.Newline && jsNeedsNewline(an.tokens[k-1], tok) {
+			break
+		}
+		if tok.is(",") || tok.is(";") || tok.is(")") || tok.is("]") || tok.is("}") {
+			break
+		}
+		if tok.Kind == jsTemplate && strings.HasPrefix(tok.Text, "}") {
+			break
+		}
+		if an.match[k] > k {
+			k = an.match[k]
+		}
+	}
+	return k - 1
+}
+
+// jsMatchBrackets finds matching brackets, template literal
+// substitutions are treated as brackets
+func jsMatchBrackets(tokens []jsToken) []int {
+	match := make([]int, len(tokens))
+	stack := []int{}
+	for i, tok := range tokens {
+		match[i] = -1
+
+		closes, opens := false, false
+		switch {
+		case tok.Kind == jsPunct:
+			switch tok.Text {
+			case "(", "[", "{":
+				opens = true
+			case ")", "]", "}":
+				closes = true
+			}
+		case tok.Kind == jsTemplate:
+			closes = strings.HasPrefix(tok.Text, "}")
+			opens = strings.HasSuffix(tok.Text, "${")
+		}
+
+		if closes && len(stack) > 0 {
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			match[open] = i
+			match[i] = open
+		}
+		if opens {
+			stack = append(stack, i)
+		}
+	}
+	return match
+}
+
+// assignNames assigns short names to the variables in scope and its children,
+// avoiding names in used and taken
+func assignNames(scope *jsScope, used, taken map[string]bool) {
+	inner := taken
+	if !scope.unsafe && len(scope.order) > 0 {
+		inner = make(map[string]bool, len(taken)+len(scope.order))
+		for name := range taken {
+			inner[name] = true
+		}
+
+		scope.renamed = make(map[string]string, len(scope.order))
+		n := 0
+		for _, name := range scope.order {
+			if scope.kept[name] {
+				continue
+			}
+			for {
+				short := jsShortName(n)
+				n++
+				if !used[short] && !inner[short] && !jsReserved[short] {
+					scope.renamed[name] = short
+					inner[short] = true
+					break
+				}
+			}
+		}
+	}
+
+	for _, child := range scope.children {
+		assignNames(child, used, inner)
+	}
+}
+
+const (
+	jsNameStart = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_$"
+	jsNamePart  = jsNameStart + "0123456789"
+)
+
+// jsShortName returns n-th short identifier
+func jsShortName(n int) string {
+	name := []byte{jsNameStart[n%len(jsNameStart)]}
+	n /= len(jsNameStart)
+	for n > 0 {
+		n--
+		name = append(name, jsNamePart[n%len(jsNamePart)])
+		n /= len(jsNamePart)
+	}
+	return string(name)
+}
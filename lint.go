@@ -0,0 +1,192 @@
+package livepkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Issue kinds reported by Lint
+const (
+	// MissingDependency is reported when a file uses a package namespace
+	// without depending on the file that provides it
+	MissingDependency = "missing-dependency"
+	// UnusedDependency is reported when a file depends on a file that
+	// provides packages, but doesn't use any of their namespaces
+	UnusedDependency = "unused-dependency"
+)
+
+// Issue is a problem found by Lint
+type Issue struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+// String returns issue as "path:line:col: message"
+func (issue Issue) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", issue.Path, issue.Line, issue.Col, issue.Message)
+}
+
+// Lint checks how JavaScript sources use package namespaces declared
+// with package("name", ...). It reports files that use a namespace without
+// depending on the file that provides it and depends calls to files whose
+// namespaces are never used. The result is cached until the next Reload
+// that reports changes.
+// Do not modify this list!
+func (b *Bundle) Lint() []Issue {
+	snap := b.Snapshot()
+
+	b.cache.mu.Lock()
+	defer b.cache.mu.Unlock()
+
+	b.cache.use(snap)
+	if b.cache.issues != nil {
+		return b.cache.issues
+	}
+
+	sources := snap.Sources
+
+	providers := make(map[string][]string)
+	provides := make(map[string][]string)
+	for _, src := range sources {
+		for _, name := range src.Provides {
+			providers[name] = append(providers[name], src.Path)
+		}
+		provides[src.Path] = src.Provides
+	}
+
+	issues := []Issue{}
+	for _, src := range sources {
+		if src.Ext != ".js" {
+			continue
+		}
+		issues = append(issues, lintSource(src, providers, provides)...)
+	}
+
+	sort.SliceStable(issues, func(i, k int) bool {
+		if issues[i].Path != issues[k].Path {
+			return issues[i].Path < issues[k].Path
+		}
+		if issues[i].Line != issues[k].Line {
+			return issues[i].Line < issues[k].Line
+		}
+		return issues[i].Col < issues[k].Col
+	})
+	b.cache.issues = issues
+	return issues
+}
+
+// lintSource checks namespace usage in a single source
+func lintSource(src *Source, providers, provides map[string][]string) []Issue {
+	issues := []Issue{}
+
+	own := make(map[string]bool, len(src.Provides))
+	for _, name := range src.Provides {
+		own[name] = true
+	}
+	deps := make(map[string]bool, len(src.Deps))
+	for _, dep := range src.Deps {
+		deps[dep] = true
+	}
+
+	used := make(map[string]bool)
+	reported := make(map[string]bool)
+	for _, ref := range jsNamespaceRefs(src.Content) {
+		// the longest package name is the one that is referenced
+		longest, matched := "", []string{}
+		for name := range providers {
+			if own[name] || !isNameRef(ref.Text, name) {
+				continue
+			}
+			matched = append(matched, name)
+			if len(name) > len(longest) {
+				longest = name
+			}
+		}
+		// references to the own namespace, e.g. ui.other.go in package
+		// ui.other, don't use the parent namespace ui
+		self := false
+		for name := range own {
+			self = self || isNameRef(ref.Text, name) && len(name) > len(longest)
+		}
+		if self {
+			continue
+		}
+		for _, name := range matched {
+			used[name] = true
+		}
+		if longest == "" || reported[longest] {
+			continue
+		}
+
+		depends := false
+		for _, provider := range providers[longest] {
+			depends = depends || deps[provider] || provider == src.Path
+		}
+		if !depends {
+			reported[longest] = true
+			issues = append(issues, Issue{
+				Kind:    MissingDependency,
+				Path:    src.Path,
+				Line:    ref.Line,
+				Col:     ref.Col,
+				Message: fmt.Sprintf("uses %s without depending on %s", longest, strings.Join(providers[longest], " or ")),
+			})
+		}
+	}
+
+	for _, imp := range src.Imports {
+		names := provides[imp.Path]
+		if len(names) == 0 || imp.Path == src.Path {
+			continue
+		}
+		unused := true
+		for _, name := range names {
+			unused = unused && !used[name] && !own[name]
+		}
+		if unused {
+			issues = append(issues, Issue{
+				Kind:    UnusedDependency,
+				Path:    src.Path,
+				Line:    imp.Line,
+				Col:     imp.Col,
+				Message: fmt.Sprintf("depends on %s, but doesn't use %s", imp.Spec, strings.Join(names, ", ")),
+			})
+		}
+	}
+
+	return issues
+}
+
+// jsNamespaceRefs returns dotted names such as "ui.wanderer.renderTo"
+// used in JavaScript source, Text of the returned tokens is the full name
+func jsNamespaceRefs(data []byte) []jsToken {
+	refs := []jsToken{}
+	tokens := jsTokenize(data)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Kind != jsIdent || i > 0 && (tokens[i-1].is(".") || tokens[i-1].is("?.")) {
+			continue
+		}
+		// object literal keys
+		if i+1 < len(tokens) && tokens[i+1].is(":") && i > 0 && (tokens[i-1].is("{") || tokens[i-1].is(",")) {
+			continue
+		}
+
+		ref := tok
+		for i+2 < len(tokens) && tokens[i+1].is(".") && tokens[i+2].Kind == jsIdent {
+			ref.Text += "." + tokens[i+2].Text
+			i += 2
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// isNameRef returns whether ref refers to package name or something inside it
+func isNameRef(ref, name string) bool {
+	return ref == name || strings.HasPrefix(ref, name+".")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/raintreeinc/livepkg"
+)
+
+// build writes the production bundle into a directory and returns the exit code
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: liveserver build [flags] main...")
+		flags.PrintDefaults()
+	}
+	root := flags.String("root", ".", "root directory")
+	out := flags.String("out", "dist", "output directory")
+	minify := flags.Bool("minify", false, "minify merged bundles")
+	aliases := aliasFlag{}
+	flags.Var(aliases, "alias", "dependency alias as prefix=target (repeatable)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	bundle := livepkg.NewBundle(http.Dir(*root), mainPaths(flags.Args())...)
+	bundle.Aliases = aliases
+	bundle.Minify = *minify
+	if _, err := bundle.Reload(); err != nil {
+		// cycles and missing dependencies would produce a broken bundle
+		printError(err)
+		return 1
+	}
+
+	if err := bundle.Build(*out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
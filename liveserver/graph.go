@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/raintreeinc/livepkg"
+)
+
+// graph writes the dependency graph and returns the exit code
+func graph(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: liveserver graph [flags] main...")
+		flags.PrintDefaults()
+	}
+	root := flags.String("root", ".", "root directory")
+	format := flags.String("format", "dot", "output format: dot, json or mermaid")
+	output := flags.String("o", "", "output file (default stdout)")
+	aliases := aliasFlag{}
+	flags.Var(aliases, "alias", "dependency alias as prefix=target (repeatable)")
+	flags.Parse(args)
+
+	var write func(*livepkg.Graph, io.Writer) error
+	switch *format {
+	case "dot":
+		write = (*livepkg.Graph).WriteDOT
+	case "json":
+		write = (*livepkg.Graph).WriteJSON
+	case "mermaid":
+		write = (*livepkg.Graph).WriteMermaid
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	bundle := livepkg.NewBundle(http.Dir(*root), mainPaths(flags.Args())...)
+	bundle.Aliases = aliases
+	if _, err := bundle.Reload(); err != nil {
+		// the graph shows cycles and missing files
+		printError(err)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := write(bundle.Graph(), out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
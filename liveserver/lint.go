@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/raintreeinc/livepkg"
+)
+
+// lint prints namespace usage issues and returns the exit code
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: liveserver lint [flags] main...")
+		flags.PrintDefaults()
+	}
+	root := flags.String("root", ".", "root directory")
+	aliases := aliasFlag{}
+	flags.Var(aliases, "alias", "dependency alias as prefix=target (repeatable)")
+	flags.Parse(args)
+
+	bundle := livepkg.NewBundle(http.Dir(*root), mainPaths(flags.Args())...)
+	bundle.Aliases = aliases
+	_, err := bundle.Reload()
+	if err != nil {
+		printError(err)
+	}
+
+	issues := bundle.Lint()
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	if err != nil || len(issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// mainPaths returns main files given relative to root as bundle paths
+func mainPaths(names []string) []string {
+	main := []string{}
+	for _, name := range names {
+		main = append(main, path.Clean("/"+name))
+	}
+	return main
+}
+
+// printError prints reload errors, a line for each error
+// and each dependency that is part of a cycle
+func printError(err error) {
+	if errs, ok := err.(livepkg.Errors); ok {
+		for _, err := range errs {
+			printError(err)
+		}
+		return
+	}
+
+	var cycle *livepkg.CycleError
+	if errors.As(err, &cycle) {
+		for _, c := range cycle.Cycles {
+			for _, edge := range c.Edges {
+				fmt.Fprintf(os.Stderr, "%s: cycle in dependencies: %s -> %s\n", edge.Pos, edge.Path, edge.Dep)
+			}
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/raintreeinc/livepkg"
 )
@@ -11,10 +14,55 @@ var (
 	addr = flag.String("listen", ":8000", "address to listen on")
 	dev  = flag.Bool("dev", true, "development mode")
 	root = flag.String("root", ".", "root directory")
+
+	minify  = flag.Bool("minify", false, "minify merged bundles")
+	modules = flag.Bool("modules", false, "load ES modules as module scripts in development mode")
+	quiet   = flag.Duration("quiet", livepkg.DefaultQuiet, "wait for changes to settle before reloading")
+
+	aliases = aliasFlag{}
 )
 
+func init() {
+	flag.Var(aliases, "alias", "dependency alias as prefix=target, e.g. @shared/=/vendor/shared/ (repeatable)")
+}
+
+// aliasFlag collects -alias flags
+type aliasFlag map[string]string
+
+func (aliases aliasFlag) String() string {
+	pairs := []string{}
+	for from, to := range aliases {
+		pairs = append(pairs, from+"="+to)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (aliases aliasFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("alias must be prefix=target, got %q", value)
+	}
+	aliases[value[:i]] = value[i+1:]
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(lint(os.Args[2:]))
+		case "graph":
+			os.Exit(graph(os.Args[2:]))
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 	pkg := livepkg.NewServer(http.Dir(*root), *dev, flag.Args()...)
+	pkg.Bundle().Minify = *minify
+	pkg.Modules = *modules
+	pkg.Quiet = *quiet
+	pkg.Bundle().Aliases = aliases
 	http.ListenAndServe(*addr, pkg)
 }
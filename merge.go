@@ -0,0 +1,263 @@
+package livepkg
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Merged is a production bundle of all sources with the same extension
+type Merged struct {
+	Name    string     // file name of the bundle, e.g. "~pkg.js"
+	Content []byte     // merged content
+	Map     *SourceMap // source map for Content
+}
+
+// mergeCache contains merged bundles and lint issues
+// for the snapshot they were created from
+type mergeCache struct {
+	mu       sync.Mutex
+	snapshot *Snapshot
+	merged   map[string]*Merged
+	issues   []Issue // nil until Lint is called
+}
+
+// use clears the cache when it was created for a different snapshot,
+// cache.mu must be held
+func (cache *mergeCache) use(snap *Snapshot) {
+	if cache.merged == nil || cache.snapshot != snap {
+		cache.snapshot = snap
+		cache.merged = make(map[string]*Merged)
+		cache.issues = nil
+	}
+}
+
+// Merged returns the bundle for ext together with a source map.
+// For JavaScript the bundle starts with the package manager, sources that
+// use import or export statements are left out, see ModuleError.
+// The result is cached until the next Reload that reports changes.
+func (b *Bundle) Merged(ext string) *Merged {
+	snap := b.Snapshot()
+
+	b.cache.mu.Lock()
+	defer b.cache.mu.Unlock()
+
+	b.cache.use(snap)
+	if merged, ok := b.cache.merged[ext]; ok {
+		return merged
+	}
+
+	preamble := ""
+	if ext == ".js" {
+		preamble = jspackage
+	}
+
+	sources := snap.ByExt(ext)
+	if ext == ".js" {
+		sources = classicScripts(sources)
+	}
+	merged := b.merge(sources, ext, preamble)
+	if b.Minify {
+		if err := minifyMerged(ext, merged); err != nil {
+			// the bundle still works, only larger
+			log.Printf("minifying %s failed: %v", merged.Name, err)
+		}
+	}
+	switch ext {
+	case ".css":
+		merged.Content = append(merged.Content, "/*# sourceMappingURL="+merged.Name+".map */\n"...)
+	default:
+		merged.Content = append(merged.Content, "//# sourceMappingURL="+merged.Name+".map\n"...)
+	}
+
+	b.cache.merged[ext] = merged
+	return merged
+}
+
+// ModuleError is returned when sources use import or export statements,
+// which cannot be merged into a bundle loaded as a classic script
+type ModuleError struct {
+	Paths []string
+}
+
+// Error is for implementing error interface
+func (err *ModuleError) Error() string {
+	return "ES modules cannot be bundled: " + strings.Join(err.Paths, ", ")
+}
+
+// checkModules returns a *ModuleError when JavaScript sources contain ES modules
+func (b *Bundle) checkModules() error {
+	paths := []string{}
+	for _, src := range b.Snapshot().ByExt(".js") {
+		if src.Module {
+			paths = append(paths, src.Path)
+		}
+	}
+	if len(paths) > 0 {
+		return &ModuleError{Paths: paths}
+	}
+	return nil
+}
+
+// classicScripts returns sources that are not ES modules
+func classicScripts(sources []*Source) []*Source {
+	scripts := make([]*Source, 0, len(sources))
+	for _, src := range sources {
+		if !src.Module {
+			scripts = append(scripts, src)
+		}
+	}
+	return scripts
+}
+
+// merge concatenates sources with ext after preamble and creates a source map
+func (b *Bundle) merge(sources []*Source, ext string, preamble string) *Merged {
+	bundled := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		bundled[src.Path] = true
+	}
+	isBundled := func(path string) bool { return bundled[path] }
+
+	merged := &Merged{
+		Name: "~pkg" + ext,
+		Map: &SourceMap{
+			Version: 3,
+			File:    "~pkg" + ext,
+			Sources: []string{},
+			Names:   []string{},
+		},
+	}
+	smap := merged.Map
+
+	// index of source paths in source map
+	indexes := make(map[string]int)
+	sourceIndex := func(path string, content []byte) int {
+		if index, ok := indexes[path]; ok {
+			return index
+		}
+		index := len(smap.Sources)
+		indexes[path] = index
+		smap.Sources = append(smap.Sources, strings.TrimPrefix(path, "/"))
+
+		var text *string
+		if content != nil {
+			s := string(content)
+			text = &s
+		}
+		smap.SourcesContent = append(smap.SourcesContent, text)
+		return index
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(preamble)
+	lines := strings.Count(preamble, "\n")
+
+	mappings := []Mapping{}
+	for _, src := range sources {
+		header := fmt.Sprintf("\n/* \"%s\" */\n", src.Path)
+		buf.WriteString(header)
+		lines += strings.Count(header, "\n")
+
+		data := src.Processed
+		if ext == ".css" {
+			data = cssMerge(src.Processed, src.Path, b.Aliases, isBundled)
+		}
+		mappings = append(mappings, sourceMappings(src, lines, data, smap, sourceIndex)...)
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+		lines += bytes.Count(data, []byte{'\n'}) + 1
+	}
+
+	smap.Encode(mappings)
+	merged.Content = buf.Bytes()
+	return merged
+}
+
+// minifyMerged minifies merged content and updates its source map,
+// merged is left unmodified when the source maps cannot be composed
+func minifyMerged(ext string, merged *Merged) error {
+	var content []byte
+	var smap *SourceMap
+	switch ext {
+	case ".js":
+		content, smap = minifyJS(merged.Content)
+	case ".css":
+		content, smap = minifyCSS(merged.Content)
+	default:
+		return nil
+	}
+
+	composed, err := composeMaps(smap, merged.Map)
+	if err != nil {
+		return err
+	}
+	composed.File = merged.Map.File
+
+	merged.Content = append(content, '\n')
+	merged.Map = composed
+	return nil
+}
+
+// sourceMappings returns mappings for data from src that starts at offset line
+func sourceMappings(src *Source, offset int, data []byte, smap *SourceMap, sourceIndex func(string, []byte) int) []Mapping {
+	mappings := []Mapping{}
+
+	var inner []Mapping
+	if src.Map != nil {
+		var err error
+		inner, err = src.Map.Decode()
+		if err != nil {
+			inner = nil
+		}
+	}
+
+	if inner == nil {
+		index := sourceIndex(src.Path, src.Content)
+		lines := bytes.Count(data, []byte{'\n'}) + 1
+		for line := 0; line < lines; line++ {
+			mappings = append(mappings, Mapping{
+				GenLine: offset + line,
+				Source:  index,
+				Line:    line,
+				Name:    -1,
+			})
+		}
+		return mappings
+	}
+
+	// the processor created a source map for the file
+	names := make(map[int]int)
+	for _, mapping := range inner {
+		mapping.GenLine += offset
+		if mapping.Source >= 0 && mapping.Source < len(src.Map.Sources) {
+			path, content := src.Path, src.Content
+			if len(src.Map.Sources) > 1 {
+				path = resolvePath(src.Path, src.Map.Sources[mapping.Source])
+				content = nil
+				if mapping.Source < len(src.Map.SourcesContent) && src.Map.SourcesContent[mapping.Source] != nil {
+					content = []byte(*src.Map.SourcesContent[mapping.Source])
+				}
+			}
+			mapping.Source = sourceIndex(path, content)
+		} else {
+			mapping.Source = -1
+		}
+
+		if mapping.Name >= 0 && mapping.Name < len(src.Map.Names) {
+			index, ok := names[mapping.Name]
+			if !ok {
+				index = len(smap.Names)
+				names[mapping.Name] = index
+				smap.Names = append(smap.Names, src.Map.Names[mapping.Name])
+			}
+			mapping.Name = index
+		} else {
+			mapping.Name = -1
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
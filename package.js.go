@@ -53,6 +53,30 @@ const jspackage = `
 		};
 	}
 
+	// modules contains CommonJS modules by path
+	var modules = {};
+
+	// define registers and runs a CommonJS module,
+	// deps maps require arguments to module paths
+	package.define = function define(path, deps, factory){
+		var module = { id: path, exports: {} };
+		modules[path] = module;
+
+		function require(spec){
+			return package.require(deps.hasOwnProperty(spec) ? deps[spec] : spec);
+		}
+		factory.call(module.exports, require, module, module.exports);
+	};
+
+	// require returns the exports of CommonJS module at path
+	package.require = function require(path){
+		var module = modules[path];
+		if(!module){
+			throw new Error("module not found: " + path);
+		}
+		return module.exports;
+	};
+
 	global.depends = function depends(filename){};
 })(window || this);
 `
@@ -0,0 +1,71 @@
+package livepkg
+
+// Processor transforms the content of a source file, e.g. transpiling or
+// minifying it. Process reads src.Processed and replaces it with the result.
+// Processors must not modify src.Content.
+//
+// A processor may set src.Map to a source map from its input to its output,
+// it will be composed with the maps of the previous processors and
+// used when creating the source map for the bundle.
+type Processor interface {
+	Process(src *Source) error
+}
+
+// ProcessorFunc is an adapter to allow use of ordinary functions as processors
+type ProcessorFunc func(src *Source) error
+
+// Process calls fn(src)
+func (fn ProcessorFunc) Process(src *Source) error { return fn(src) }
+
+// AddProcessor registers processors for files with extension ext.
+// Processors for the same extension are run in the order they were added.
+// AddProcessor must be called before the bundle is reloaded.
+func (b *Bundle) AddProcessor(ext string, procs ...Processor) {
+	if b.Processors == nil {
+		b.Processors = make(map[string][]Processor)
+	}
+	b.Processors[ext] = append(b.Processors[ext], procs...)
+}
+
+// process runs all processors registered for src.Ext, starting from src.Content,
+// CommonJS sources are wrapped after processing
+func (b *Bundle) process(src *Source) error {
+	src.Processed = src.Content
+	src.Map = nil
+	for _, proc := range b.Processors[src.Ext] {
+		inner := src.Map
+		src.Map = nil
+		if err := proc.Process(src); err != nil {
+			return &ProcessError{Path: src.Path, Err: err}
+		}
+
+		switch {
+		case src.Map == nil:
+			// processor didn't change positions
+			src.Map = inner
+		case inner != nil:
+			composed, err := composeMaps(src.Map, inner)
+			if err != nil {
+				return &ProcessError{Path: src.Path, Err: err}
+			}
+			src.Map = composed
+		}
+	}
+
+	if src.CommonJS {
+		src.Processed = wrapCommonJS(src)
+	}
+	return nil
+}
+
+// ProcessError is returned when a processor fails to process a file
+type ProcessError struct {
+	Path string
+	Err  error
+}
+
+// Error is for implementing error interface
+func (err *ProcessError) Error() string { return err.Path + ": " + err.Err.Error() }
+
+// Unwrap returns the processor error
+func (err *ProcessError) Unwrap() error { return err.Err }
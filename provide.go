@@ -0,0 +1,156 @@
+package livepkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProviderError is returned when a dependency on a package name
+// has no providing file or has multiple providing files
+type ProviderError struct {
+	Pos       string   // position of the dependency as "path:line:col"
+	Name      string   // package name
+	Providers []string // files that provide the package
+}
+
+// Error is for implementing error interface
+func (err *ProviderError) Error() string {
+	if len(err.Providers) == 0 {
+		return fmt.Sprintf("%s: package %q is not provided by any file", err.Pos, err.Name)
+	}
+	return fmt.Sprintf("%s: package %q is provided by multiple files: %s",
+		err.Pos, err.Name, strings.Join(err.Providers, ", "))
+}
+
+// providerRetry is how often unresolved package names are retried
+// when the bundle is polled for changes
+const providerRetry = 2 * time.Second
+
+// fileSuffixes contains suffixes of file names, such as "jquery.min",
+// that are not file extensions
+var fileSuffixes = map[string]bool{
+	"min": true, "slim": true, "umd": true, "esm": true, "cjs": true, "mjs": true,
+	"bundle": true, "prod": true, "production": true, "dev": true, "development": true,
+	"ts": true, "tsx": true, "jsx": true,
+}
+
+// isPackageName returns whether spec looks like a package name such as
+// "ui" or "ui.wanderer" instead of a file name
+func isPackageName(spec string) bool {
+	if spec == "" || mime.TypeByExtension(path.Ext(spec)) != "" {
+		return false
+	}
+	if i := strings.LastIndexByte(spec, '.'); i >= 0 && fileSuffixes[spec[i+1:]] {
+		return false
+	}
+	for _, part := range strings.Split(spec, ".") {
+		if part == "" || isDigit(part[0]) {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			c := part[i]
+			if !isLetter(c) && !isDigit(c) && c != '_' && c != '$' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// providerIndex finds files in Root that declare packages
+type providerIndex struct {
+	mu    sync.Mutex
+	stale bool // files have to be rescanned before use
+	files map[string]*providerFile
+}
+
+// providerFile contains packages declared in a file
+type providerFile struct {
+	modTime  time.Time
+	provides []string
+}
+
+// invalidate marks that the files have to be rescanned on next lookup
+func (index *providerIndex) invalidate() {
+	index.mu.Lock()
+	index.stale = true
+	index.mu.Unlock()
+}
+
+// lookup returns sorted list of files in root that provide package name
+func (index *providerIndex) lookup(root http.FileSystem, name string) []string {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.files == nil || index.stale {
+		index.scan(root)
+		index.stale = false
+	}
+
+	providers := []string{}
+	for file, info := range index.files {
+		for _, provided := range info.provides {
+			if provided == name {
+				providers = append(providers, file)
+			}
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// scan updates the index from JavaScript files in root,
+// only files that have been modified are read
+func (index *providerIndex) scan(root http.FileSystem) {
+	files := make(map[string]*providerFile)
+	skip := func(dir string) bool {
+		return path.Base(dir) == "node_modules" || isBuildDir(root, dir)
+	}
+	walkFiles(root, "/", -1, skip, func(name string) {
+		// files starting with "~" are generated, such as "~pkg.js"
+		if path.Ext(name) != ".js" || strings.HasPrefix(path.Base(name), "~") {
+			return
+		}
+		file, err := root.Open(name)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil {
+			return
+		}
+		if prev, ok := index.files[name]; ok && prev.modTime.Equal(stat.ModTime()) {
+			files[name] = prev
+			return
+		}
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return
+		}
+		files[name] = &providerFile{
+			modTime:  stat.ModTime(),
+			provides: scanJS(data, nil).Provides,
+		}
+	})
+	index.files = files
+}
+
+// isBuildDir returns whether dir contains output of Bundle.Build
+func isBuildDir(root http.FileSystem, dir string) bool {
+	file, err := root.Open(path.Join(dir, "~pkg.js"))
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
@@ -40,6 +40,9 @@ var Reloader = {};
 		}
 
 		var result = JSON.parse(xhr.responseText);
+		modules = result.modules;
+		generation = result.generation;
+		showErrors(result.errors || []);
 		LoadFiles(result.files);
 
 		if(typeof WebSocket !== 'undefined'){
@@ -52,11 +55,34 @@ var Reloader = {};
 
 	var loading = {};
 	var unloaded = [];
+	var known = {};
+	var modules = false;
+	var generation = 0;
 
 	Reloader.loading = loading;
 	Reloader.unloaded = unloaded;
+	Reloader.known = known;
+
+	// isModule returns whether file is loaded as a module script
+	function isModule(file){
+		return modules && file.ext == ".js" && file.module;
+	}
+
+	// needsReload returns whether file cannot be swapped without reloading the page,
+	// because modules are cached by their dependents
+	function needsReload(file){
+		return file != null && (isModule(file) || file.commonjs);
+	}
+
+	// loadable returns whether file can be injected into the page
+	function loadable(file){
+		return file.ext == ".js" || file.ext == ".css" || file.ext == ".html";
+	}
 
 	function LoadFiles(files){
+		for(var i = 0; i < files.length; i += 1){
+			known[files[i].path] = files[i];
+		}
 		unloaded = files;
 		flush();
 
@@ -91,6 +117,7 @@ var Reloader = {};
 
 		// tries to load a file, returns true if it started loading
 		function tryLoad(file){
+			if(!loadable(file)){ return true; }
 			for(var i = 0; i < file.deps.length; i += 1){
 				if(loading[file.deps[i]]){ return false; }
 			}
@@ -135,6 +162,12 @@ var Reloader = {};
 		switch(file.ext){
 		case ".js":
 			var asset = document.createElement("script");
+			if(isModule(file)){
+				// modules are identified by url, imports must get the same instance
+				asset.type = "module";
+				asset.src = abs(file.path);
+				break;
+			}
 			asset.src = abs(file.path) + "?" + stamp;
 			break;
 		case ".css":
@@ -142,6 +175,11 @@ var Reloader = {};
 			asset.href = abs(file.path) + "?" + stamp;
 			asset.rel = "stylesheet";
 			break;
+		case ".html":
+			var asset = document.createElement("link");
+			asset.href = abs(file.path) + "?" + stamp;
+			asset.rel = "import";
+			break;
 		default:
 			return;
 		}
@@ -174,6 +212,44 @@ var Reloader = {};
 		window.location.reload();
 	}
 
+	// showErrors shows an overlay with the errors of the last reload,
+	// the overlay is removed when there are no errors
+	function showErrors(errors){
+		Reloader.errors = errors;
+		var overlay = document.getElementById("~pkg-errors");
+		if(errors.length == 0){
+			if(overlay){ overlay.parentNode.removeChild(overlay); }
+			return;
+		}
+		for(var i = 0; i < errors.length; i += 1){
+			console.error("reloader", errors[i].message);
+		}
+		if(!document.body){ return; }
+
+		if(!overlay){
+			overlay = document.createElement("pre");
+			overlay.id = "~pkg-errors";
+			overlay.style.cssText = "position:fixed;left:0;right:0;bottom:0;z-index:2147483647;" +
+				"margin:0;padding:1em;max-height:50%;overflow:auto;" +
+				"background:#300;color:#fdd;font:12px monospace;white-space:pre-wrap;";
+			overlay.onclick = function(){ overlay.style.display = "none"; };
+			document.body.appendChild(overlay);
+		}
+		overlay.style.display = "";
+		overlay.textContent = errors.map(function(err){ return err.message; }).join("\n\n");
+	}
+
+	// refreshAssets reloads stylesheets that reference the asset
+	function refreshAssets(change){
+		var path = (change.next || change.prev).path;
+		for(var name in known){
+			var file = known[name];
+			if(!file.assets || file.assets.indexOf(path) < 0){ continue; }
+			var asset = swapFile(file, file);
+			asset.onload = onFileChanged(change);
+		}
+	}
+
 	function onFileChanged(change){
 		return function(){
 			console.log("reloader", "%", change);
@@ -182,6 +258,26 @@ var Reloader = {};
 		};
 	}
 
+	function applyChange(change){
+		if(change.next != null){
+			known[change.next.path] = change.next;
+		} else {
+			delete known[change.prev.path];
+		}
+
+		if(!loadable(change.next || change.prev)){
+			refreshAssets(change);
+		} else if(change.prev == null){
+			var asset = injectFile(change.next);
+			asset.onload = onFileChanged(change);
+		} else if(change.next == null){
+			removeFile(change.prev);
+		} else {
+			var asset = swapFile(change.prev, change.next);
+			asset.onload = onFileChanged(change);
+		}
+	}
+
 	var OnceConnected = false;
 	var ConnectionDelay = 100;
 	function ListenChanges(livepath){
@@ -190,20 +286,31 @@ var Reloader = {};
 
 		ws.addEventListener('message', function(ev){
 			if(ev.data === "") { return; }
-			var change = JSON.parse(ev.data);
-			if(change.deps){
+			var message = JSON.parse(ev.data);
+			if(message.errors){
+				showErrors(message.errors);
+				return;
+			}
+
+			if(message.generation <= generation){ return; }
+			if(message.since != generation){
+				// missed a change set
 				reload();
 				return;
 			}
+			generation = message.generation;
 
-			if(change.prev == null){
-				var asset = injectFile(change.next);
-				asset.onload = onFileChanged(change);
-			} else if(change.next == null){
-				removeFile(change.prev);
-			} else {
-				var asset = swapFile(change.prev, change.next);
-				asset.onload = onFileChanged(change);
+			var changes = message.changes;
+			for(var i = 0; i < changes.length; i += 1){
+				var change = changes[i];
+				if(change.deps || needsReload(change.prev) || needsReload(change.next)){
+					reload();
+					return;
+				}
+			}
+			// changes are sorted by dependencies
+			for(var i = 0; i < changes.length; i += 1){
+				applyChange(changes[i]);
 			}
 		});
 
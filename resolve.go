@@ -0,0 +1,304 @@
+package livepkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Resolver finds the path of dependency spec imported by source from
+type Resolver interface {
+	Resolve(root http.FileSystem, from *Source, spec string) (string, error)
+}
+
+// ResolverFunc is an adapter to allow use of ordinary functions as resolvers
+type ResolverFunc func(root http.FileSystem, from *Source, spec string) (string, error)
+
+// Resolve calls fn(root, from, spec)
+func (fn ResolverFunc) Resolve(root http.FileSystem, from *Source, spec string) (string, error) {
+	return fn(root, from, spec)
+}
+
+// ErrNotResolved is returned when a resolver cannot find the dependency
+var ErrNotResolved = errors.New("dependency not resolved")
+
+// NodeResolver resolves dependencies similarly to Node.js.
+//
+// Relative paths are resolved against the importing file, trying the
+// extension of the importer and index files of directories. Bare specifiers,
+// such as "lodash", that don't exist next to the importer are searched from
+// node_modules directories of the importer's directory and its parents.
+// Packages are resolved using "exports", "module" and "main" fields of
+// package.json, "module" and the "import" condition are used only for
+// ES modules. Bare ES module imports that cannot be found are left to the
+// browser, e.g. for an import map.
+type NodeResolver struct{}
+
+// Resolve implements Resolver
+func (NodeResolver) Resolve(root http.FileSystem, from *Source, spec string) (string, error) {
+	r := &nodeResolve{
+		root:   root,
+		ext:    path.Ext(from.Path),
+		module: from.Module,
+	}
+
+	if isRelativeSpec(spec) {
+		if resolved, ok := r.path(resolvePath(from.Path, spec)); ok {
+			return resolved, nil
+		}
+		return "", ErrNotResolved
+	}
+
+	// depends("file.js") refers to a file next to the importer
+	// and depends("dir/") to the index file of a directory
+	if strings.HasSuffix(spec, "/") {
+		if resolved, ok := r.dir(resolvePath(from.Path, spec)); ok {
+			return resolved, nil
+		}
+	} else if resolved := resolvePath(from.Path, spec); r.exists(resolved) {
+		return resolved, nil
+	}
+
+	name, subpath := splitPackageSpec(spec)
+	for dir := path.Dir(from.Path); ; dir = path.Dir(dir) {
+		if resolved, ok := r.pkg(path.Join(dir, "node_modules", name), subpath); ok {
+			return resolved, nil
+		}
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+	return "", ErrNotResolved
+}
+
+// isRelativeSpec returns whether spec is a path relative to the importer or root
+func isRelativeSpec(spec string) bool {
+	return spec == "." || spec == ".." ||
+		strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") ||
+		strings.HasPrefix(spec, "/")
+}
+
+// splitPackageSpec splits bare specifier into package name and subpath,
+// subpath is empty or starts with "/"
+func splitPackageSpec(spec string) (name, subpath string) {
+	parts := strings.Split(spec, "/")
+	n := 1
+	if strings.HasPrefix(spec, "@") && len(parts) > 1 {
+		n = 2
+	}
+	name = strings.Join(parts[:n], "/")
+	return name, spec[len(name):]
+}
+
+// nodeResolve contains the state of a single resolution
+type nodeResolve struct {
+	root   http.FileSystem
+	ext    string // extension tried for paths without one
+	module bool   // importer is an ES module
+
+	// visited contains directories whose main is being resolved,
+	// so that mains pointing to each other don't recurse forever
+	visited map[string]bool
+}
+
+// packageJSON contains the fields of package.json used for resolving
+type packageJSON struct {
+	Main    string          `json:"main"`
+	Module  string          `json:"module"`
+	Exports json.RawMessage `json:"exports"`
+}
+
+// exists returns whether p is a file
+func (r *nodeResolve) exists(p string) bool {
+	file, err := r.root.Open(p)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	return err == nil && !stat.IsDir()
+}
+
+// packageJSON reads package.json from dir
+func (r *nodeResolve) packageJSON(dir string) (*packageJSON, bool) {
+	file, err := r.root.Open(path.Join(dir, "package.json"))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, false
+	}
+	pkg := &packageJSON{}
+	if err := json.Unmarshal(data, pkg); err != nil {
+		return nil, false
+	}
+	return pkg, true
+}
+
+// path resolves p as a file or as a directory
+func (r *nodeResolve) path(p string) (string, bool) {
+	if r.exists(p) {
+		return p, true
+	}
+	if r.ext != "" && r.exists(p+r.ext) {
+		return p + r.ext, true
+	}
+	return r.dir(p)
+}
+
+// dir resolves the main file of directory p
+func (r *nodeResolve) dir(p string) (string, bool) {
+	if pkg, ok := r.packageJSON(p); ok {
+		main := pkg.Main
+		if r.module && pkg.Module != "" {
+			main = pkg.Module
+		}
+		if r.visited == nil {
+			r.visited = make(map[string]bool)
+		}
+		r.visited[path.Clean(p)] = true
+
+		// main pointing back at a directory being resolved would recurse forever
+		if target := path.Join(p, main); main != "" && !r.visited[target] {
+			if resolved, ok := r.path(target); ok {
+				return resolved, true
+			}
+		}
+	}
+
+	if index := path.Join(p, "index"+r.ext); r.ext != "" && r.exists(index) {
+		return index, true
+	}
+	return "", false
+}
+
+// pkg resolves subpath in package located at dir
+func (r *nodeResolve) pkg(dir, subpath string) (string, bool) {
+	pkg, ok := r.packageJSON(dir)
+	if !ok || len(pkg.Exports) == 0 || string(pkg.Exports) == "null" {
+		return r.path(dir + subpath)
+	}
+
+	target, ok := r.exports(pkg.Exports, "."+subpath)
+	if !ok {
+		return "", false
+	}
+	resolved := path.Join(dir, target)
+	return resolved, r.exists(resolved)
+}
+
+// exports finds the target of subpath, such as "." or "./feature",
+// in package.json exports
+func (r *nodeResolve) exports(exports json.RawMessage, subpath string) (string, bool) {
+	members, ok := jsonMembers(exports)
+	if !ok || len(members) == 0 || !strings.HasPrefix(members[0].Key, ".") {
+		// exports for the main entry point only
+		if subpath != "." {
+			return "", false
+		}
+		return r.target(exports, "")
+	}
+
+	for _, member := range members {
+		if member.Key == subpath {
+			return r.target(member.Value, "")
+		}
+	}
+	for _, member := range members {
+		i := strings.IndexByte(member.Key, '*')
+		if i < 0 {
+			continue
+		}
+		prefix, suffix := member.Key[:i], member.Key[i+1:]
+		if len(subpath) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(subpath, prefix) && strings.HasSuffix(subpath, suffix) {
+			return r.target(member.Value, subpath[len(prefix):len(subpath)-len(suffix)])
+		}
+	}
+	return "", false
+}
+
+// target resolves conditional exports target, "*" in target is replaced
+// with match
+func (r *nodeResolve) target(value json.RawMessage, match string) (string, bool) {
+	var target string
+	if err := json.Unmarshal(value, &target); err == nil {
+		if !strings.HasPrefix(target, "./") {
+			return "", false
+		}
+		return strings.Replace(target, "*", match, -1), true
+	}
+
+	var alternatives []json.RawMessage
+	if err := json.Unmarshal(value, &alternatives); err == nil {
+		for _, alternative := range alternatives {
+			if target, ok := r.target(alternative, match); ok {
+				return target, true
+			}
+		}
+		return "", false
+	}
+
+	members, _ := jsonMembers(value)
+	for _, member := range members {
+		if !r.condition(member.Key) {
+			continue
+		}
+		if target, ok := r.target(member.Value, match); ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// condition returns whether exports condition applies to the importer
+func (r *nodeResolve) condition(name string) bool {
+	switch name {
+	case "browser", "default":
+		return true
+	case "import":
+		return r.module
+	case "require":
+		return !r.module
+	}
+	return false
+}
+
+// jsonMember is a member of JSON object
+type jsonMember struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// jsonMembers returns members of JSON object in their original order
+func jsonMembers(data json.RawMessage) ([]jsonMember, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+
+	members := []jsonMember{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		members = append(members, jsonMember{Key: key, Value: value})
+	}
+	return members, true
+}
@@ -19,6 +19,16 @@ import (
 
 // Server implements a live reloading server for JS
 type Server struct {
+	// Modules enables loading sources that use import or export statements
+	// as type="module" scripts in development mode
+	Modules bool
+	// Quiet is how long there must be no changes on disk before the
+	// changes are sent to the clients as a single change set
+	Quiet time.Duration
+	// MaxWait is the longest time changes are delayed by further changes
+	// during the quiet period
+	MaxWait time.Duration
+
 	root   http.FileSystem
 	main   []string
 	dev    bool
@@ -29,57 +39,204 @@ type Server struct {
 
 	mu      sync.RWMutex
 	clients map[*websocket.Conn]struct{}
+
+	// statemu guards the errors and the generation of the last change set
+	// sent to the clients
+	statemu    sync.Mutex
+	errors     []errorInfo
+	generation uint64
 }
 
+// DefaultQuiet is the default quiet period of a server
+const DefaultQuiet = 100 * time.Millisecond
+
+// DefaultMaxWait is the default maximum delay of changes
+const DefaultMaxWait = 2 * time.Second
+
 // NewServer returns a new server
 func NewServer(root http.FileSystem, dev bool, main ...string) *Server {
 	server := &Server{
+		Quiet:   DefaultQuiet,
+		MaxWait: DefaultMaxWait,
+
 		root:    root,
 		main:    main,
 		dev:     dev,
 		bundle:  NewBundle(root, main...),
 		clients: make(map[*websocket.Conn]struct{}),
+		errors:  []errorInfo{},
 	}
 	server.socket = websocket.Handler(server.livechanges)
 	return server
 }
 
+// Bundle returns the bundle used by the server, it can be used to
+// configure processors before the server starts serving requests
+func (server *Server) Bundle() *Bundle { return server.bundle }
+
 // init initializes the bundle and starts monitoring disk for changes
 func (server *Server) init() {
-	_, err := server.bundle.Reload()
+	snap, _, err := server.bundle.ReloadSnapshot()
 	if err != nil {
 		log.Println(err)
 	}
+	server.setErrors(err)
+	server.advance(snap.Generation)
 	if server.dev {
 		go server.monitor()
 	}
 }
 
-// broadcast sends a change to all connected clients
-func (server *Server) broadcast(change *Change) {
+// broadcast sends a message to all connected clients
+func (server *Server) broadcast(message interface{}) {
 	server.mu.RLock()
 	defer server.mu.RUnlock()
 	for ws := range server.clients {
-		websocket.JSON.Send(ws, change)
+		websocket.JSON.Send(ws, message)
+	}
+}
+
+// setErrors updates the errors shown by the reloader,
+// it returns false when the errors didn't change
+func (server *Server) setErrors(err error) bool {
+	infos := errorInfos(err)
+
+	server.statemu.Lock()
+	defer server.statemu.Unlock()
+	if sameErrorInfos(server.errors, infos) {
+		return false
 	}
+	server.errors = infos
+	return true
+}
+
+// advance updates the generation sent to the clients and
+// returns the previous one
+func (server *Server) advance(generation uint64) (since uint64) {
+	server.statemu.Lock()
+	defer server.statemu.Unlock()
+	since, server.generation = server.generation, generation
+	return since
+}
+
+// currentErrors returns the errors of the last reload
+func (server *Server) currentErrors() []errorInfo {
+	server.statemu.Lock()
+	defer server.statemu.Unlock()
+	return server.errors
 }
 
-// monitor monitors for changes on disk
+// monitor monitors for changes on disk, file system events are used
+// when the bundle is loaded from a local directory
 func (server *Server) monitor() {
-	for {
-		changes, err := server.bundle.Reload()
-		if err != nil {
-			log.Println(err)
+	if dir, ok := server.bundle.Root.(http.Dir); ok {
+		w, err := newWatcher(string(dir))
+		if err == nil {
+			server.watch(w)
+			return
+		}
+		if err != errWatchUnsupported {
+			log.Printf("watching %s failed, polling for changes: %v", dir, err)
 		}
-		if len(changes) > 0 {
-			for _, change := range changes {
-				server.broadcast(change)
+	}
+	server.poll()
+}
+
+// poll periodically reloads the bundle, after a change it reloads
+// until nothing changes during the quiet period or MaxWait has passed
+func (server *Server) poll() {
+	for {
+		snap, changes, err := server.bundle.ReloadSnapshot()
+		deadline := time.Now().Add(server.MaxWait)
+		for len(changes) > 0 && time.Now().Before(deadline) {
+			time.Sleep(server.Quiet)
+			next, more, moreErr := server.bundle.ReloadSnapshot()
+			if len(more) == 0 {
+				break
 			}
+			snap, changes, err = next, mergeChanges(changes, more), moreErr
+			sortChanges(changes, snap.Sources)
 		}
+		server.changed(snap, changes, err)
 		time.Sleep(500 * time.Millisecond)
 	}
 }
 
+// watch reloads files reported by the watcher after there have been
+// no changes during the quiet period or MaxWait has passed, changes of
+// files that don't affect the bundle are ignored
+func (server *Server) watch(w watcher) {
+	defer w.Close()
+	server.watchSources(w)
+
+	for path := range w.Changes() {
+		if !server.bundle.affects(path) {
+			continue
+		}
+		paths := []string{path}
+		all := path == ""
+
+		quiet := time.After(server.Quiet)
+		deadline := time.After(server.MaxWait)
+	collect:
+		for {
+			select {
+			case path, ok := <-w.Changes():
+				if !ok {
+					break collect
+				}
+				if !server.bundle.affects(path) {
+					continue
+				}
+				paths = append(paths, path)
+				all = all || path == ""
+				quiet = time.After(server.Quiet)
+			case <-quiet:
+				break collect
+			case <-deadline:
+				break collect
+			}
+		}
+
+		var snap *Snapshot
+		var changes []*Change
+		var err error
+		if all {
+			snap, changes, err = server.bundle.ReloadSnapshot()
+		} else {
+			snap, changes, err = server.bundle.reloadFiles(paths)
+		}
+		server.changed(snap, changes, err)
+		server.watchSources(w)
+	}
+}
+
+// watchSources adds directories of all sources to w,
+// so that sources outside of the watched directories are also monitored
+func (server *Server) watchSources(w watcher) {
+	for _, src := range server.bundle.All() {
+		w.Add(path.Dir(src.Path))
+	}
+}
+
+// changed sends changes and errors from a reload to the clients,
+// snap is the snapshot the changes lead to
+func (server *Server) changed(snap *Snapshot, changes []*Change, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	if len(changes) > 0 {
+		server.broadcast(&ChangeSet{
+			Since:      server.advance(snap.Generation),
+			Generation: snap.Generation,
+			Changes:    changes,
+		})
+	}
+	if server.setErrors(err) {
+		server.broadcast(map[string][]errorInfo{"errors": server.currentErrors()})
+	}
+}
+
 // ServeHTTP implements http.Server
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	server.once.Do(server.init)
@@ -125,12 +282,19 @@ func (server *Server) serveLive(w http.ResponseWriter, r *http.Request) {
 func (server *Server) serveBundle(w http.ResponseWriter, r *http.Request) {
 	switch path.Base(r.URL.Path) {
 	case "~pkg.js":
+		if err := server.bundle.checkModules(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/javascript")
-		w.Write([]byte(jspackage))
-		w.Write(server.bundle.MergedByExt(".js"))
+		w.Write(server.bundle.Merged(".js").Content)
+	case "~pkg.js.map":
+		server.sourceMap(w, r, ".js")
 	case "~pkg.css":
 		w.Header().Set("Content-Type", "text/css; charset=utf-8")
-		w.Write(server.bundle.MergedByExt(".css"))
+		w.Write(server.bundle.Merged(".css").Content)
+	case "~pkg.css.map":
+		server.sourceMap(w, r, ".css")
 	case "~info":
 		w.WriteHeader(http.StatusForbidden)
 	case "~live":
@@ -140,16 +304,49 @@ func (server *Server) serveBundle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sourceMap serves source map for the bundle with ext
+func (server *Server) sourceMap(w http.ResponseWriter, r *http.Request, ext string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	data, err := json.Marshal(server.bundle.Merged(ext).Map)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed create JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(data)
+}
+
 // info serves information about all the files
 func (server *Server) info(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var info struct {
-		Files []*Source `json:"files"`
+		Files   []*Source         `json:"files"`
+		Modules bool              `json:"modules"`
+		Aliases map[string]string `json:"aliases"`
+		Lint    []Issue           `json:"lint"`
+		Errors  []errorInfo       `json:"errors"`
+
+		Generation uint64 `json:"generation"`
+		// Impact is included for files listed with ?impact=path
+		Impact []*Impact `json:"impact,omitempty"`
 	}
 
 	var err error
-	info.Files = server.bundle.All()
+	snap := server.bundle.Snapshot()
+	info.Files = snap.Sources
+	info.Modules = server.Modules
+	info.Aliases = server.bundle.Aliases
+	info.Lint = server.bundle.Lint()
+	info.Errors = server.currentErrors()
+	info.Generation = snap.Generation
+	for _, file := range r.URL.Query()["impact"] {
+		if !strings.HasPrefix(file, "/") {
+			file = "/" + file
+		}
+		info.Impact = append(info.Impact, server.bundle.Impact(file))
+	}
 
 	data, err := json.MarshalIndent(info, "", "\t")
 	if err != nil {
@@ -0,0 +1,66 @@
+package livepkg
+
+// Snapshot is the state of a bundle after a reload.
+// Snapshots are never modified, a reload that changes sources
+// creates a new snapshot with a higher generation.
+type Snapshot struct {
+	// Generation is increased by one for every reload that has changes
+	Generation uint64
+	// Sources contains the sources sorted by dependencies
+	Sources []*Source
+
+	// errs contains errors from sorting Sources
+	errs Errors
+
+	byPath     map[string]*Source
+	byExt      map[string][]*Source
+	deps       map[string][]*Source
+	dependents map[string][]*Source // includes dependents of missing files and assets
+}
+
+// newSnapshot creates a snapshot from sorted sources and builds the indexes
+func newSnapshot(generation uint64, sources []*Source, errs Errors) *Snapshot {
+	snap := &Snapshot{
+		Generation: generation,
+		Sources:    sources,
+		errs:       errs,
+
+		byPath:     make(map[string]*Source, len(sources)),
+		byExt:      make(map[string][]*Source),
+		deps:       make(map[string][]*Source, len(sources)),
+		dependents: make(map[string][]*Source),
+	}
+
+	for _, src := range sources {
+		snap.byPath[src.Path] = src
+		snap.byExt[src.Ext] = append(snap.byExt[src.Ext], src)
+	}
+	for _, src := range sources {
+		for _, dep := range src.Deps {
+			if target, ok := snap.byPath[dep]; ok {
+				snap.deps[src.Path] = append(snap.deps[src.Path], target)
+			}
+			snap.dependents[dep] = append(snap.dependents[dep], src)
+		}
+		for _, asset := range src.Assets {
+			snap.dependents[asset] = append(snap.dependents[asset], src)
+		}
+	}
+	return snap
+}
+
+// Source returns the source with path, nil if it isn't in the snapshot
+func (snap *Snapshot) Source(path string) *Source { return snap.byPath[path] }
+
+// ByExt returns sorted sources with ext
+// Do not modify this list!
+func (snap *Snapshot) ByExt(ext string) []*Source { return snap.byExt[ext] }
+
+// Deps returns the loaded dependencies of path in the order they are declared
+// Do not modify this list!
+func (snap *Snapshot) Deps(path string) []*Source { return snap.deps[path] }
+
+// Dependents returns sources that depend directly on path,
+// in the order of Sources, path doesn't have to be in the snapshot
+// Do not modify this list!
+func (snap *Snapshot) Dependents(path string) []*Source { return snap.dependents[path] }
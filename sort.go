@@ -2,8 +2,9 @@ package livepkg
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"text/tabwriter"
 )
 
@@ -13,99 +14,215 @@ func (a byType) Len() int           { return len(a) }
 func (a byType) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byType) Less(i, j int) bool { return a[i].Ext < a[j].Ext }
 
-// SortSources optimistically sorts items by dependencies
-// in case of cycles those items will be unordered
-func SortSources(sources []*Source) ([]*Source, error) {
-	order, err := sortByDeps(sources)
+// SortSources sorts sources so that dependencies come before the files that
+// depend on them. Sources in main are visited first in the given order and
+// then the rest by path, dependencies are visited in the order they are
+// declared, so the result is the same for the same set of sources.
+// Sources in a cycle are kept together in the order they were found.
+func SortSources(sources []*Source, main ...string) ([]*Source, error) {
+	order, err := sortByDeps(sources, main)
 	// sort.Sort(byType(order))
 	return order, err
 }
 
-// sortByDeps sorts files as best as possible
+// depSorter contains the state of Tarjan's strongly connected components
+// algorithm, components are found in topological order
+type depSorter struct {
+	sources map[string]*Source
+
+	next    int
+	index   map[string]int
+	lowlink map[string]int
+	onStack map[string]bool
+	stack   []*Source
+
+	order   []*Source
+	cycles  [][]*Source
+	missing []error
+}
+
+// sortByDeps sorts files by dependencies in linear time,
 // missing files will be ignored in sorting and error returned
-// in presence of import cycles the cyclic files will be randomized
-func sortByDeps(initial []*Source) (order []*Source, err error) {
-	missing := []string{}
-	sorted := make(map[string]bool)
-	order = []*Source{}
+func sortByDeps(initial []*Source, main []string) (order []*Source, err error) {
+	sorter := &depSorter{
+		sources: make(map[string]*Source, len(initial)),
+		index:   make(map[string]int, len(initial)),
+		lowlink: make(map[string]int, len(initial)),
+		onStack: make(map[string]bool, len(initial)),
+		order:   make([]*Source, 0, len(initial)),
+	}
 
-	sources := make(map[string]*Source, len(initial))
+	paths := make([]string, 0, len(initial))
 	for _, src := range initial {
-		sources[src.Path] = src
+		sorter.sources[src.Path] = src
+		paths = append(paths, src.Path)
 	}
+	sort.Strings(paths)
 
-	// brute-force topological sort
-	for pass := 0; pass < 100; pass += 1 {
-		changes := false
-	next:
-		for _, src := range sources {
-			if sorted[src.Path] {
-				continue
-			}
+	for _, path := range append(append([]string{}, main...), paths...) {
+		src, ok := sorter.sources[path]
+		if !ok {
+			continue
+		}
+		if _, visited := sorter.index[path]; !visited {
+			sorter.visit(src)
+		}
+	}
 
-			for _, dep := range src.Deps {
-				if sorted[dep] {
-					continue
-				}
-				if _, exists := sources[dep]; !exists {
-					sorted[dep] = true
-					missing = append(missing, dep)
-					continue
-				}
-				continue next
-			}
+	var errs Errors
+	if len(sorter.cycles) > 0 {
+		errs = append(errs, newCycleError(sorter.cycles))
+	}
+	for _, missing := range sorter.missing {
+		errs = append(errs, missing)
+	}
+	if len(errs) == 1 {
+		return sorter.order, errs[0]
+	}
+	return sorter.order, errs.Nilify()
+}
 
-			changes = true
-			sorted[src.Path] = true
-			order = append(order, src)
+// visit visits src and its dependencies
+func (sorter *depSorter) visit(src *Source) {
+	index := sorter.next
+	sorter.next++
+	sorter.index[src.Path] = index
+	sorter.lowlink[src.Path] = index
+	sorter.stack = append(sorter.stack, src)
+	sorter.onStack[src.Path] = true
+
+	selfdep := false
+	for _, dep := range src.Deps {
+		next, exists := sorter.sources[dep]
+		if !exists {
+			sorter.missing = append(sorter.missing, &MissingDependencyError{
+				Importer: src.Path,
+				Path:     dep,
+				Pos:      depPos(src, dep),
+			})
+			continue
 		}
+		selfdep = selfdep || dep == src.Path
 
-		if len(order) == len(sources) {
-			if len(missing) > 0 {
-				return order, fmt.Errorf("some sources are missing: %v", missing)
+		if _, visited := sorter.index[dep]; !visited {
+			sorter.visit(next)
+			if sorter.lowlink[dep] < sorter.lowlink[src.Path] {
+				sorter.lowlink[src.Path] = sorter.lowlink[dep]
 			}
-			return order, nil
+		} else if sorter.onStack[dep] && sorter.index[dep] < sorter.lowlink[src.Path] {
+			sorter.lowlink[src.Path] = sorter.index[dep]
 		}
+	}
 
-		// found an import loop
-		if !changes {
-			break
-		}
+	if sorter.lowlink[src.Path] != index {
+		return
 	}
 
-	// there is a problem with some of the unsorted items
-	for _, src := range sources {
-		if sorted[src.Path] {
-			continue
-		}
-		order = append(order, src)
+	// src is the root of a strongly connected component
+	start := len(sorter.stack) - 1
+	for sorter.stack[start] != src {
+		start--
+	}
+	component := append([]*Source{}, sorter.stack[start:]...)
+	sorter.stack = sorter.stack[:start]
+	for _, member := range component {
+		sorter.onStack[member.Path] = false
 	}
 
-	help := formatUnsorted(sources, sorted)
-	errtext := fmt.Sprintf("cycle in dependencies:\n%s\n", help)
-	if len(missing) > 0 {
-		errtext += fmt.Sprintf("some sources are missing: %s", missing)
+	sorter.order = append(sorter.order, component...)
+	if len(component) > 1 || selfdep {
+		sorter.cycles = append(sorter.cycles, component)
 	}
+}
 
-	return order, errors.New(errtext)
+// Edge is a dependency of Path on Dep declared at Pos
+type Edge struct {
+	Path string `json:"path"`
+	Dep  string `json:"dep"`
+	Pos  string `json:"pos"` // position of the dependency as "path:line:col"
 }
 
-func formatUnsorted(sources map[string]*Source, sorted map[string]bool) string {
-	var buf bytes.Buffer
-	tw := tabwriter.NewWriter(&buf, 0, 8, 0, '\t', 0)
+// Cycle is a group of sources that depend on each other
+type Cycle struct {
+	// Paths contains the sources in the order they were found
+	Paths []string `json:"paths"`
+	// Edges contains the dependencies between the sources in the cycle,
+	// for a simple cycle they form a chain from the first source back to it
+	Edges []Edge `json:"edges"`
+}
 
-	for _, src := range sources {
-		if sorted[src.Path] {
-			continue
+// CycleError is returned when sources have cyclic dependencies
+type CycleError struct {
+	Cycles []Cycle `json:"cycles"`
+}
+
+// newCycleError creates an error from strongly connected components
+func newCycleError(components [][]*Source) *CycleError {
+	err := &CycleError{}
+	for _, component := range components {
+		inCycle := make(map[string]bool, len(component))
+		for _, src := range component {
+			inCycle[src.Path] = true
 		}
 
-		for _, dep := range src.Deps {
-			if !sorted[dep] {
-				fmt.Fprintf(tw, "    \t%s\t->\t%s\n", src.Path, dep)
+		cycle := Cycle{}
+		for _, src := range component {
+			cycle.Paths = append(cycle.Paths, src.Path)
+			for _, dep := range src.Deps {
+				if inCycle[dep] {
+					cycle.Edges = append(cycle.Edges, Edge{Path: src.Path, Dep: dep, Pos: depPos(src, dep)})
+				}
 			}
 		}
+		err.Cycles = append(err.Cycles, cycle)
+	}
+	return err
+}
+
+// Error is for implementing error interface
+func (err *CycleError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("cycle in dependencies:\n")
+
+	tw := tabwriter.NewWriter(&buf, 0, 8, 0, '\t', 0)
+	for _, cycle := range err.Cycles {
+		for _, edge := range cycle.Edges {
+			fmt.Fprintf(tw, "    \t%s\t->\t%s\n", edge.Pos, edge.Dep)
+		}
 	}
 	tw.Flush()
 
-	return buf.String()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// MissingDependencyError is returned when a source depends on
+// a file that couldn't be loaded
+type MissingDependencyError struct {
+	Importer string `json:"importer"` // source that declares the dependency
+	Path     string `json:"path"`     // resolved path of the dependency
+	Pos      string `json:"pos"`      // position of the dependency as "path:line:col"
+
+	// CaseMismatch is the file that differs from Path only by case
+	CaseMismatch string `json:"caseMismatch,omitempty"`
+	// Suggestions contains files with similar names or other extensions
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Error is for implementing error interface
+func (err *MissingDependencyError) Error() string {
+	if err.CaseMismatch != "" {
+		return fmt.Sprintf("%s: %s is missing, file name differs by case: %s", err.Pos, err.Path, err.CaseMismatch)
+	}
+	if len(err.Suggestions) > 0 {
+		return fmt.Sprintf("%s: %s is missing, did you mean %s?", err.Pos, err.Path, strings.Join(err.Suggestions, " or "))
+	}
+	return fmt.Sprintf("%s: %s is missing", err.Pos, err.Path)
+}
+
+// depPos returns location of dep in src
+func depPos(src *Source, dep string) string {
+	if imp := src.ImportOf(dep); imp != nil {
+		return imp.Pos(src.Path)
+	}
+	return src.Path
 }
@@ -0,0 +1,307 @@
+package livepkg
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SourceMap is a version 3 source map
+type SourceMap struct {
+	Version        int       `json:"version"`
+	File           string    `json:"file,omitempty"`
+	SourceRoot     string    `json:"sourceRoot,omitempty"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent,omitempty"`
+	Names          []string  `json:"names"`
+	Mappings       string    `json:"mappings"`
+}
+
+// Mapping maps a position in generated file to a position in source,
+// all lines and columns are 0-based
+type Mapping struct {
+	GenLine int
+	GenCol  int
+
+	Source int // index in SourceMap.Sources, -1 when unmapped
+	Line   int
+	Col    int
+	Name   int // index in SourceMap.Names, -1 when unnamed
+}
+
+// ErrInvalidMappings is returned when source map mappings cannot be decoded
+var ErrInvalidMappings = errors.New("invalid source map mappings")
+
+const vlqChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// Decode decodes the mappings of the source map
+func (m *SourceMap) Decode() ([]Mapping, error) {
+	mappings := []Mapping{}
+
+	var source, line, col, name int
+	genLine := 0
+	for _, group := range strings.Split(m.Mappings, ";") {
+		genCol := 0
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+			fields, err := vlqDecode(segment)
+			if err != nil {
+				return nil, err
+			}
+
+			mapping := Mapping{GenLine: genLine, Source: -1, Name: -1}
+			switch len(fields) {
+			case 1, 4, 5:
+			default:
+				return nil, ErrInvalidMappings
+			}
+
+			genCol += fields[0]
+			mapping.GenCol = genCol
+			if len(fields) >= 4 {
+				source += fields[1]
+				line += fields[2]
+				col += fields[3]
+				mapping.Source, mapping.Line, mapping.Col = source, line, col
+			}
+			if len(fields) == 5 {
+				name += fields[4]
+				mapping.Name = name
+			}
+			mappings = append(mappings, mapping)
+		}
+		genLine++
+	}
+
+	return mappings, nil
+}
+
+// Encode sets the mappings of the source map
+func (m *SourceMap) Encode(mappings []Mapping) {
+	sorted := append([]Mapping{}, mappings...)
+	sort.SliceStable(sorted, func(i, k int) bool {
+		if sorted[i].GenLine != sorted[k].GenLine {
+			return sorted[i].GenLine < sorted[k].GenLine
+		}
+		return sorted[i].GenCol < sorted[k].GenCol
+	})
+
+	var buf strings.Builder
+	var source, line, col, name int
+	genLine, genCol := 0, 0
+	for i, mapping := range sorted {
+		if mapping.GenLine != genLine {
+			for ; genLine < mapping.GenLine; genLine++ {
+				buf.WriteByte(';')
+			}
+			genCol = 0
+		} else if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		vlqEncode(&buf, mapping.GenCol-genCol)
+		genCol = mapping.GenCol
+		if mapping.Source < 0 {
+			continue
+		}
+
+		vlqEncode(&buf, mapping.Source-source)
+		vlqEncode(&buf, mapping.Line-line)
+		vlqEncode(&buf, mapping.Col-col)
+		source, line, col = mapping.Source, mapping.Line, mapping.Col
+		if mapping.Name >= 0 {
+			vlqEncode(&buf, mapping.Name-name)
+			name = mapping.Name
+		}
+	}
+
+	m.Mappings = buf.String()
+}
+
+// vlqEncode writes value as base64 VLQ
+func vlqEncode(buf *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 31
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 32
+		}
+		buf.WriteByte(vlqChars[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
+
+// vlqDecode decodes all base64 VLQ values in segment
+func vlqDecode(segment string) ([]int, error) {
+	values := []int{}
+	value, shift := 0, uint(0)
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(vlqChars, segment[i])
+		if digit < 0 {
+			return nil, ErrInvalidMappings
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+
+		if value&1 != 0 {
+			values = append(values, -(value >> 1))
+		} else {
+			values = append(values, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, ErrInvalidMappings
+	}
+	return values, nil
+}
+
+// mappingIndex allows looking up mappings by generated position
+type mappingIndex [][]Mapping
+
+// newMappingIndex groups mappings by generated line
+func newMappingIndex(mappings []Mapping) mappingIndex {
+	index := mappingIndex{}
+	for _, mapping := range mappings {
+		for len(index) <= mapping.GenLine {
+			index = append(index, nil)
+		}
+		index[mapping.GenLine] = append(index[mapping.GenLine], mapping)
+	}
+	for _, line := range index {
+		sort.SliceStable(line, func(i, k int) bool { return line[i].GenCol < line[k].GenCol })
+	}
+	return index
+}
+
+// lookup finds the original position for a generated position
+func (index mappingIndex) lookup(line, col int) (Mapping, bool) {
+	if line < 0 || line >= len(index) {
+		return Mapping{}, false
+	}
+	segments := index[line]
+	i := sort.Search(len(segments), func(i int) bool { return segments[i].GenCol > col }) - 1
+	if i < 0 || segments[i].Source < 0 {
+		return Mapping{}, false
+	}
+
+	mapping := segments[i]
+	mapping.GenLine, mapping.GenCol = line, col
+	mapping.Col += col - segments[i].GenCol
+	return mapping, true
+}
+
+// composeMaps returns a source map from outer generated file to inner
+// sources, where outer maps from some intermediate file that is the
+// generated file of inner
+func composeMaps(outer, inner *SourceMap) (*SourceMap, error) {
+	outerMappings, err := outer.Decode()
+	if err != nil {
+		return nil, err
+	}
+	innerMappings, err := inner.Decode()
+	if err != nil {
+		return nil, err
+	}
+	index := newMappingIndex(innerMappings)
+
+	composed := &SourceMap{
+		Version:        3,
+		File:           outer.File,
+		SourceRoot:     inner.SourceRoot,
+		Sources:        inner.Sources,
+		SourcesContent: inner.SourcesContent,
+		Names:          append([]string{}, inner.Names...),
+	}
+
+	mappings := []Mapping{}
+	for _, mapping := range outerMappings {
+		if mapping.Source < 0 {
+			continue
+		}
+		original, ok := index.lookup(mapping.Line, mapping.Col)
+		if !ok {
+			continue
+		}
+		original.GenLine, original.GenCol = mapping.GenLine, mapping.GenCol
+		if original.Name < 0 && mapping.Name >= 0 && mapping.Name < len(outer.Names) {
+			original.Name = len(composed.Names)
+			composed.Names = append(composed.Names, outer.Names[mapping.Name])
+		}
+		mappings = append(mappings, original)
+	}
+	composed.Encode(mappings)
+
+	return composed, nil
+}
+
+// mapWriter writes generated output and records mappings to the input
+type mapWriter struct {
+	buf  bytes.Buffer
+	line int
+	col  int
+
+	mappings  []Mapping
+	names     []string
+	nameIndex map[string]int
+}
+
+// mark maps current output position to 0-based line and col in the input,
+// name is the original name of the identifier or empty
+func (w *mapWriter) mark(line, col int, name string) {
+	mapping := Mapping{
+		GenLine: w.line,
+		GenCol:  w.col,
+		Source:  0,
+		Line:    line,
+		Col:     col,
+		Name:    -1,
+	}
+	if name != "" {
+		if w.nameIndex == nil {
+			w.nameIndex = make(map[string]int)
+		}
+		index, ok := w.nameIndex[name]
+		if !ok {
+			index = len(w.names)
+			w.nameIndex[name] = index
+			w.names = append(w.names, name)
+		}
+		mapping.Name = index
+	}
+	w.mappings = append(w.mappings, mapping)
+}
+
+// WriteString writes s to the output
+func (w *mapWriter) WriteString(s string) {
+	w.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		w.line += strings.Count(s, "\n")
+		w.col = len(s) - i - 1
+	} else {
+		w.col += len(s)
+	}
+}
+
+// Map returns source map from the output to the input
+func (w *mapWriter) Map() *SourceMap {
+	m := &SourceMap{
+		Version: 3,
+		Sources: []string{""},
+		Names:   append([]string{}, w.names...),
+	}
+	m.Encode(w.mappings)
+	return m
+}
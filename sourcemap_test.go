@@ -0,0 +1,98 @@
+package livepkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSourceMapEncodeDecode(t *testing.T) {
+	mappings := []Mapping{
+		{GenLine: 0, GenCol: 0, Source: 0, Line: 0, Col: 0, Name: -1},
+		{GenLine: 0, GenCol: 7, Source: 0, Line: 1, Col: 4, Name: 0},
+		{GenLine: 2, GenCol: 3, Source: 1, Line: 100, Col: 0, Name: -1},
+		{GenLine: 2, GenCol: 9, Source: -1, Name: -1},
+		{GenLine: 3, GenCol: 0, Source: 0, Line: 2, Col: 31, Name: 0},
+	}
+
+	m := &SourceMap{Version: 3}
+	m.Encode(mappings)
+
+	decoded, err := m.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(mappings) {
+		t.Fatalf("got %v", decoded)
+	}
+	for i := range mappings {
+		if decoded[i] != mappings[i] {
+			t.Errorf("%d: got %v expected %v", i, decoded[i], mappings[i])
+		}
+	}
+}
+
+func TestMergedSourceMap(t *testing.T) {
+	fs := filesystem{
+		"/main.js": "depends('lib.js');\nMAIN();",
+		"/lib.js":  "LIB1();\nLIB2();",
+	}
+
+	bundle := NewBundle(fs, "/main.js")
+	// header processor moves everything one line down
+	bundle.AddProcessor(".js", ProcessorFunc(func(src *Source) error {
+		if src.Path != "/lib.js" {
+			return nil
+		}
+		src.Processed = append([]byte("HEADER();\n"), src.Processed...)
+		src.Map = &SourceMap{Version: 3, Sources: []string{"lib.js"}}
+		src.Map.Encode([]Mapping{
+			{GenLine: 1, Source: 0, Line: 0, Name: -1},
+			{GenLine: 2, Source: 0, Line: 1, Name: -1},
+		})
+		return nil
+	}))
+
+	if _, err := bundle.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := bundle.Merged(".js")
+	if !bytes.HasSuffix(merged.Content, []byte("//# sourceMappingURL=~pkg.js.map\n")) {
+		t.Errorf("missing sourceMappingURL")
+	}
+
+	mappings, err := merged.Map.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := newMappingIndex(mappings)
+
+	lines := strings.Split(string(merged.Content), "\n")
+	expect := map[string]struct {
+		source string
+		line   int
+	}{
+		"LIB2();": {"lib.js", 1},
+		"MAIN();": {"main.js", 1},
+	}
+	for genLine, text := range lines {
+		exp, ok := expect[text]
+		if !ok {
+			continue
+		}
+		delete(expect, text)
+
+		mapping, ok := index.lookup(genLine, 0)
+		if !ok {
+			t.Errorf("%q is not mapped", text)
+			continue
+		}
+		if merged.Map.Sources[mapping.Source] != exp.source || mapping.Line != exp.line {
+			t.Errorf("%q mapped to %s:%d", text, merged.Map.Sources[mapping.Source], mapping.Line)
+		}
+	}
+	if len(expect) > 0 {
+		t.Errorf("lines not found %v", expect)
+	}
+}
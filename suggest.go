@@ -0,0 +1,192 @@
+package livepkg
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the number of similar files suggested for a missing dependency
+const maxSuggestions = 3
+
+// annotateMissing adds similarly named files in b.Root
+// to the missing dependency errors in err
+func (b *Bundle) annotateMissing(err error) {
+	var files []string
+	eachError(err, func(err error) {
+		missing, ok := err.(*MissingDependencyError)
+		if !ok || missing.CaseMismatch != "" {
+			return
+		}
+		if files == nil {
+			files = rootFiles(b.Root)
+		}
+		missing.CaseMismatch, missing.Suggestions = suggestFiles(files, missing.Path)
+	})
+}
+
+// checkCase returns errors for dependencies that were loaded using a path
+// that differs from the file name by case, which happens on case-insensitive
+// file systems, but would fail elsewhere
+func (b *Bundle) checkCase(sources []*Source) Errors {
+	errs := Errors{}
+	listings := make(map[string][]string)
+	loaded := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		loaded[src.Path] = true
+	}
+
+	for _, src := range sources {
+		for _, dep := range src.Deps {
+			if !loaded[dep] {
+				continue
+			}
+			// dependencies of unrooted main files are unrooted too
+			if actual := actualCase(b.Root, dep, listings); actual != path.Clean("/"+dep) {
+				errs = append(errs, &MissingDependencyError{
+					Importer:     src.Path,
+					Path:         dep,
+					Pos:          depPos(src, dep),
+					CaseMismatch: actual,
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// actualCase returns p with the names used on the file system,
+// listings caches the directory contents
+func actualCase(root http.FileSystem, p string, listings map[string][]string) string {
+	actual := "/"
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		names, ok := listings[actual]
+		if !ok {
+			names = readNames(root, actual)
+			listings[actual] = names
+		}
+
+		found := name
+		for _, candidate := range names {
+			if candidate == name {
+				found = name
+				break
+			}
+			if strings.EqualFold(candidate, name) {
+				found = candidate
+			}
+		}
+		actual = path.Join(actual, found)
+	}
+	return actual
+}
+
+// readNames returns names of the files in dir
+func readNames(root http.FileSystem, dir string) []string {
+	file, err := root.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+// rootFiles returns all files in root except in node_modules
+func rootFiles(root http.FileSystem) []string {
+	files := []string{}
+	skip := func(dir string) bool { return path.Base(dir) == "node_modules" }
+	walkFiles(root, "/", -1, skip, func(name string) {
+		files = append(files, name)
+	})
+	sort.Strings(files)
+	return files
+}
+
+// suggestFiles finds files that differ from missing only by case
+// and files that have a similar name or a different extension
+func suggestFiles(files []string, missing string) (caseMismatch string, suggestions []string) {
+	type candidate struct {
+		path     string
+		distance int
+	}
+
+	base := strings.TrimSuffix(missing, path.Ext(missing))
+	limit := 1 + len(path.Base(base))/4
+	if limit > 3 {
+		limit = 3
+	}
+
+	candidates := []candidate{}
+	for _, file := range files {
+		switch {
+		case file == missing:
+		case strings.EqualFold(file, missing):
+			caseMismatch = file
+		case strings.TrimSuffix(file, path.Ext(file)) == base:
+			// extension swap
+			candidates = append(candidates, candidate{file, 0})
+		default:
+			if distance := editDistance(file, missing); distance <= limit {
+				candidates = append(candidates, candidate{file, distance})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, k int) bool {
+		return candidates[i].distance < candidates[k].distance
+	})
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].path)
+	}
+	return caseMismatch, suggestions
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	next := make([]int, len(b)+1)
+	for k := range prev {
+		prev[k] = k
+	}
+	for i := 1; i <= len(a); i++ {
+		next[0] = i
+		for k := 1; k <= len(b); k++ {
+			cost := 1
+			if a[i-1] == b[k-1] {
+				cost = 0
+			}
+			next[k] = prev[k-1] + cost
+			if prev[k]+1 < next[k] {
+				next[k] = prev[k] + 1
+			}
+			if next[k-1]+1 < next[k] {
+				next[k] = next[k-1] + 1
+			}
+		}
+		prev, next = next, prev
+	}
+	return prev[len(b)]
+}
+
+// eachError calls fn for err and errors contained in Errors
+func eachError(err error, fn func(err error)) {
+	if errs, ok := err.(Errors); ok {
+		for _, err := range errs {
+			eachError(err, fn)
+		}
+		return
+	}
+	if err != nil {
+		fn(err)
+	}
+}
@@ -0,0 +1,18 @@
+package livepkg
+
+import "errors"
+
+// errWatchUnsupported is returned when file system events are not
+// available on the platform
+var errWatchUnsupported = errors.New("watching files is not supported")
+
+// watcher reports changes of files in a local directory
+type watcher interface {
+	// Add starts watching dir, which is relative to the watched root
+	Add(dir string) error
+	// Changes returns the channel of changed files, paths are relative to
+	// the watched root, empty path means that any file may have changed
+	Changes() <-chan string
+	// Close stops watching
+	Close() error
+}
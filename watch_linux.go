@@ -0,0 +1,232 @@
+package livepkg
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask contains the events that change the content of a directory
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher watches directories using inotify
+type inotifyWatcher struct {
+	fd      int
+	root    string
+	changes chan string
+	done    chan struct{} // closed by Close
+
+	mu      sync.Mutex
+	closed  bool
+	dirs    map[int]string // watch descriptor to directory
+	watched map[string]int // directory to watch descriptor
+}
+
+// newWatcher starts watching directories in root, except hidden
+// directories and node_modules, those can be added with Add
+func newWatcher(root string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		root:    root,
+		changes: make(chan string, 64),
+		done:    make(chan struct{}),
+		dirs:    make(map[int]string),
+		watched: make(map[string]int),
+	}
+	if err := w.addTree("/"); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Changes implements watcher
+func (w *inotifyWatcher) Changes() <-chan string { return w.changes }
+
+// Add implements watcher
+func (w *inotifyWatcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.add(dir)
+}
+
+// add adds a watch for dir, w.mu must be held
+func (w *inotifyWatcher) add(dir string) error {
+	if w.closed {
+		return nil
+	}
+	if _, ok := w.watched[dir]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, w.native(dir), inotifyMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		return err
+	}
+	w.dirs[wd] = dir
+	w.watched[dir] = wd
+	return nil
+}
+
+// addTree adds watches for dir and its subdirectories
+func (w *inotifyWatcher) addTree(dir string) error {
+	w.mu.Lock()
+	err := w.add(dir)
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(w.native(dir))
+	if err != nil {
+		return nil
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || name[0] == '.' || name == "node_modules" {
+			continue
+		}
+		if err := w.addTree(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// native returns the file system path of dir
+func (w *inotifyWatcher) native(dir string) string {
+	return filepath.Join(w.root, filepath.FromSlash(dir))
+}
+
+// Close implements watcher
+func (w *inotifyWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	// removing the watches generates IN_IGNORED events,
+	// which wakes up run and it closes the descriptor
+	for wd := range w.dirs {
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+	}
+	if len(w.dirs) == 0 {
+		return syscall.Close(w.fd)
+	}
+	return nil
+}
+
+// run reads events until the watcher is closed
+func (w *inotifyWatcher) run() {
+	defer close(w.changes)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+		if closed || err != nil || n <= 0 {
+			if closed {
+				syscall.Close(w.fd)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			name := string(nameBytes)
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			w.handle(event, name)
+		}
+	}
+}
+
+// handle reports a single event
+func (w *inotifyWatcher) handle(event *syscall.InotifyEvent, name string) {
+	if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		// events were lost
+		w.send("")
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.dirs[int(event.Wd)]
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, int(event.Wd))
+		delete(w.watched, dir)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+		// all files in the directory were removed or moved
+		w.send("")
+		return
+	}
+	if name == "" {
+		return
+	}
+
+	changed := path.Join(dir, name)
+	if event.Mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			// files may have been created before the watch was added
+			w.addTree(changed)
+		case event.Mask&syscall.IN_MOVED_FROM != 0:
+			// the watches would report the old paths
+			w.removeTree(changed)
+		}
+		// sources in the directory aren't known here
+		w.send("")
+		return
+	}
+	w.send(changed)
+}
+
+// removeTree removes watches for dir and its subdirectories
+func (w *inotifyWatcher) removeTree(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for watched, wd := range w.watched {
+		if watched == dir || strings.HasPrefix(watched, dir+"/") {
+			// the watch is forgotten after IN_IGNORED
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+}
+
+// send reports a change, unless the watcher has been closed,
+// in which case nobody reads the changes anymore
+func (w *inotifyWatcher) send(changed string) {
+	select {
+	case w.changes <- changed:
+	case <-w.done:
+	}
+}
@@ -0,0 +1,108 @@
+package livepkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Mkdir(filepath.Join(root, "ui"), 0755)
+
+	w, err := newWatcher(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	expect := func(expected string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case path := <-w.Changes():
+				if path == expected {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("didn't get change for %s", expected)
+			}
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(root, "main.js"), []byte(`depends("ui/x.js")`), 0644)
+	expect("/main.js")
+
+	ioutil.WriteFile(filepath.Join(root, "ui", "x.js"), []byte(``), 0644)
+	expect("/ui/x.js")
+
+	os.Remove(filepath.Join(root, "ui", "x.js"))
+	expect("/ui/x.js")
+
+	// directories created after starting are watched as well
+	os.Mkdir(filepath.Join(root, "lib"), 0755)
+	expect("")
+	ioutil.WriteFile(filepath.Join(root, "lib", "y.js"), []byte(``), 0644)
+	expect("/lib/y.js")
+
+	// moving a directory out of root removes all files in it
+	outside, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.Rename(filepath.Join(root, "lib"), filepath.Join(outside, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	expect("")
+
+	// and the moved directory isn't watched anymore
+	ioutil.WriteFile(filepath.Join(outside, "lib", "z.js"), []byte(``), 0644)
+	ioutil.WriteFile(filepath.Join(root, "main.js"), []byte(``), 0644)
+	for path := range w.Changes() {
+		if path == "/lib/z.js" {
+			t.Errorf("got change in moved directory")
+		}
+		if path == "/main.js" {
+			break
+		}
+	}
+}
+
+func TestWatcherCloseWithUnreadChanges(t *testing.T) {
+	root, err := ioutil.TempDir("", "livepkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	goroutines := runtime.NumGoroutine()
+	w, err := newWatcher(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more changes than fit into the channel
+	for i := 0; i < 100; i++ {
+		ioutil.WriteFile(filepath.Join(root, strconv.Itoa(i)+".js"), []byte(``), 0644)
+	}
+	time.Sleep(100 * time.Millisecond)
+	w.Close()
+
+	timeout := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(timeout) {
+			t.Fatalf("watcher didn't stop after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
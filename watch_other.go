@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package livepkg
+
+// newWatcher returns errWatchUnsupported, only Linux is supported
+func newWatcher(root string) (watcher, error) { return nil, errWatchUnsupported }